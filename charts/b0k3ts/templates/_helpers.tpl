@@ -60,3 +60,14 @@ Create the name of the service account to use
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
 {{- end }}
+
+{{/*
+A probe whose httpGet scheme follows serverTLS.enabled
+*/}}
+{{- define "b0k3ts.probe" -}}
+{{- $probe := deepCopy .probe }}
+{{- if $probe.httpGet }}
+{{- $_ := set $probe.httpGet "scheme" (ternary "HTTPS" "HTTP" (.tls | default false)) }}
+{{- end }}
+{{- toYaml $probe }}
+{{- end }}
@@ -2,11 +2,28 @@ package main
 
 import (
 	"b0k3ts/internal/app"
+	"errors"
+	"flag"
+	"os"
 )
 
 func main() {
 
-	b0k3ts := app.New()
+	// State export/import subcommands
+	//
+	if len(os.Args) > 1 && (os.Args[1] == "export" || os.Args[1] == "import") {
+		os.Exit(app.RunStateCommand(os.Args[1], os.Args[2:]))
+	}
+
+	opts, err := app.ParseOptions(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+
+	b0k3ts := app.New(opts)
 
 	b0k3ts.Preflight()
 
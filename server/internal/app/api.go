@@ -9,7 +9,13 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func HealthzCheck(c *gin.Context) {
+// HealthzCheck reports 503 while draining, so the pod is taken out of
+// service before its listener closes.
+func (app *App) HealthzCheck(c *gin.Context) {
+	if app.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
 	c.Status(http.StatusOK)
 }
 
@@ -37,17 +43,11 @@ func RegisterLocalUserRoutes(rg *gin.RouterGroup, store *auth.Store) {
 	}
 }
 
-func mustBeAdmin(c *gin.Context) bool {
-	userInfo, _ := auth.TokenToUserData(c.GetHeader("Authorization"))
-	if !userInfo.Administrator {
-		c.JSON(http.StatusForbidden, gin.H{"error": "admin required"})
+func isSelfOrAdmin(c *gin.Context, username string) bool {
+	userInfo, err := auth.VerifiedUser(c)
+	if err != nil {
 		return false
 	}
-	return true
-}
-
-func isSelfOrAdmin(c *gin.Context, username string) bool {
-	userInfo, _ := auth.TokenToUserData(c.GetHeader("Authorization"))
 	if userInfo.Administrator {
 		return true
 	}
@@ -129,7 +129,7 @@ func toSafe(rec *auth.UserRecord) userRecordSafe {
 }
 
 func (api *LocalUsersAPI) UserExists(c *gin.Context) {
-	if !mustBeAdmin(c) {
+	if _, ok := auth.VerifiedAdminOrRespond(c); !ok {
 		return
 	}
 
@@ -148,7 +148,7 @@ func (api *LocalUsersAPI) UserExists(c *gin.Context) {
 }
 
 func (api *LocalUsersAPI) EnsureUser(c *gin.Context) {
-	if !mustBeAdmin(c) {
+	if _, ok := auth.VerifiedAdminOrRespond(c); !ok {
 		return
 	}
 
@@ -167,7 +167,7 @@ func (api *LocalUsersAPI) EnsureUser(c *gin.Context) {
 }
 
 func (api *LocalUsersAPI) CreateUser(c *gin.Context) {
-	if !mustBeAdmin(c) {
+	if _, ok := auth.VerifiedAdminOrRespond(c); !ok {
 		return
 	}
 
@@ -191,7 +191,7 @@ func (api *LocalUsersAPI) CreateUser(c *gin.Context) {
 }
 
 func (api *LocalUsersAPI) GetUser(c *gin.Context) {
-	if !mustBeAdmin(c) {
+	if _, ok := auth.VerifiedAdminOrRespond(c); !ok {
 		return
 	}
 
@@ -247,7 +247,7 @@ func (api *LocalUsersAPI) ChangePassword(c *gin.Context) {
 }
 
 func (api *LocalUsersAPI) UpdatePassword(c *gin.Context) {
-	if !mustBeAdmin(c) {
+	if _, ok := auth.VerifiedAdminOrRespond(c); !ok {
 		return
 	}
 
@@ -270,7 +270,7 @@ func (api *LocalUsersAPI) UpdatePassword(c *gin.Context) {
 }
 
 func (api *LocalUsersAPI) DisableUser(c *gin.Context) {
-	if !mustBeAdmin(c) {
+	if _, ok := auth.VerifiedAdminOrRespond(c); !ok {
 		return
 	}
 
@@ -298,7 +298,7 @@ func (api *LocalUsersAPI) DisableUser(c *gin.Context) {
 }
 
 func (api *LocalUsersAPI) DeleteUser(c *gin.Context) {
-	if !mustBeAdmin(c) {
+	if _, ok := auth.VerifiedAdminOrRespond(c); !ok {
 		return
 	}
 
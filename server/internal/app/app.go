@@ -3,80 +3,162 @@ package app
 import (
 	"b0k3ts/configs"
 	"b0k3ts/internal/pkg/auth"
-	badgerDB "b0k3ts/internal/pkg/badger"
+	"b0k3ts/internal/pkg/backup"
+	"b0k3ts/internal/pkg/buckets"
+	"b0k3ts/internal/pkg/storage"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
+	"sync/atomic"
 
-	"github.com/dgraph-io/badger/v4"
 	"go.yaml.in/yaml/v4"
 )
 
 type App struct {
-	Config   configs.ServerConfig
-	BadgerDB *badger.DB
+	Options Options
+	Config  configs.ServerConfig
+	DB      storage.Store
+
+	draining atomic.Bool // set once shutdown starts
 }
 
-func New() *App {
+func New(opts Options) *App {
+	return &App{
+		Options: opts,
+		Config:  configs.ServerConfig{},
+	}
+}
 
-	// Initialize Badger
-	//
-	db := badgerDB.InitializeDatabase()
+// loadConfig reads the config file and applies flag and environment
+// overrides. The default ./config.yaml may be missing; a path given with
+// -config or B0K3TS_CONFIG may not.
+func (app *App) loadConfig() ([]byte, []setting, error) {
+	path, explicit := app.Options.configPath()
 
-	return &App{
-		Config:   configs.ServerConfig{},
-		BadgerDB: db,
+	file, err := os.ReadFile(path.value)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		path.source = "default (not found)"
+		file, err = nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := yaml.Unmarshal(file, &app.Config); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path.value, err)
+	}
+
+	return file, app.resolve(path), nil
+}
+
+// openStorage opens the storage backend chosen in config.yaml.
+func (app *App) openStorage() error {
+	db, err := storage.Open(app.Config.Storage)
+	if err != nil {
+		return err
 	}
+	app.DB = db
+	slog.Info("storage opened", "backend", db.Backend())
+	return nil
 }
 
 func (app *App) Preflight() {
 
-	// Create default user
-	store := auth.NewStore(app.BadgerDB)
+	// Load Server Config
+	//
+	file, settings, err := app.loadConfig()
+	if err != nil {
+		slog.Error("failed to load config", "err", err)
+		os.Exit(1)
+	}
 
-	created, err := store.EnsureUser("root", "b0k3ts", true)
+	// Configuring Log Level (an invalid level is reported below)
+	//
+	level, err := parseLogLevel(app.Config.LogLevel)
 	if err != nil {
-		slog.Error(err.Error())
-		return
+		level = slog.LevelDebug
 	}
+	setupLogger(level)
 
-	if !created {
-		slog.Error("skipping default user creation, user already exists")
+	// Validating Resolved Config (every problem at once, before anything
+	// is opened)
+	//
+	report(settings)
+	if problems := app.validate(); len(problems) > 0 {
+		for _, p := range problems {
+			slog.Error("invalid configuration", "err", p)
+		}
+		slog.Error("refusing to start, fix the configuration above", "problems", len(problems))
+		os.Exit(1)
 	}
 
-	// Load Server Config
+	// Opening Storage
 	//
-	file, err := os.ReadFile("config.yaml")
+	err = app.openStorage()
 	if err != nil {
-		slog.Error(err.Error())
+		slog.Error("failed to open storage", "backend", app.Config.Storage.Backend, "err", err)
 		os.Exit(1)
 	}
 
-	err = yaml.Unmarshal(file, &app.Config)
+	// Restoring From Backup (before anything else is written)
+	//
+	if app.Config.Backup.RestoreFrom != "" {
+		var key string
+		key, err = app.Config.Backup.EncryptionKey.Resolve()
+		if err == nil {
+			err = backup.Restore(app.DB, app.Config.Backup.RestoreFrom, key)
+		}
+		if err != nil {
+			slog.Error("failed to restore from backup", "file", app.Config.Backup.RestoreFrom, "err", err)
+			os.Exit(1)
+		}
+	}
+
+	// Serializing Startup Writes (replicas sharing Postgres take turns
+	// migrating and reconciling)
+	//
+	unlock, err := storage.Lock(context.Background(), app.DB, storage.LockStartup)
 	if err != nil {
-		slog.Error(err.Error())
+		slog.Error("failed to take the startup lock", "err", err)
 		os.Exit(1)
 	}
+	defer unlock()
 
-	// Saving Config on Badger
+	// Migrating Storage Schema (restored backups may predate it)
 	//
-	err = badgerDB.PutKV(app.BadgerDB, "config", file)
+	err = storage.Migrate(app.DB)
 	if err != nil {
-		slog.Error(err.Error())
+		slog.Error("failed to migrate storage", "err", err)
 		os.Exit(1)
 	}
 
-	// Configuring Log Level
-	//
-	var programLevel slog.LevelVar
-	programLevel.Set(slog.LevelDebug)
+	// Create default user
+	store := auth.NewStore(app.DB)
 
-	// Configure HandlerOptions with the LevelVar.
+	created, err := store.EnsureUser("root", "b0k3ts", true)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	if !created {
+		slog.Error("skipping default user creation, user already exists")
+	}
+
+	// Saving Config to Storage
 	//
-	handlerOptions := &slog.HandlerOptions{
-		Level: &programLevel, // Use the address of the LevelVar
+	err = storage.Settings[[]byte](app.DB).Put(storage.SettingServer, file)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
 	}
 
-	// Create the logger using a handler (e.g., TextHandler) and set as default.
-	logger := slog.New(slog.NewTextHandler(os.Stdout, handlerOptions))
-	slog.SetDefault(logger)
+	// Reconciling Connections Declared in Config
+	//
+	err = buckets.ReconcileConnections(app.DB, app.Config.Connections)
+	if err != nil {
+		slog.Error("failed to reconcile connections from config.yaml", "err", err)
+	}
 }
@@ -0,0 +1,206 @@
+package app
+
+import (
+	"b0k3ts/internal/pkg/auth"
+	"b0k3ts/internal/pkg/buckets"
+	"b0k3ts/internal/pkg/storage"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Each readiness check gets this long before it counts as failed.
+const healthCheckTimeout = 3 * time.Second
+
+const (
+	CheckOK      = "ok"
+	CheckFail    = "fail"
+	CheckSkipped = "skipped"
+
+	ReadyOK       = "ok"
+	ReadyDegraded = "degraded" // ready, but a connection is unreachable
+	ReadyFail     = "fail"
+	ReadyDraining = "draining"
+)
+
+// HealthAPI serves the liveness and readiness probes.
+type HealthAPI struct {
+	App     *App
+	Auth    *auth.Auth
+	Buckets *buckets.App
+}
+
+type checkResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type readyResponse struct {
+	Status      string                 `json:"status"`
+	Checks      map[string]checkResult `json:"checks"`
+	Connections map[string]checkResult `json:"connections,omitempty"`
+}
+
+// Livez reports that the process is up and serving. It stays 200 while
+// draining, so the pod isn't restarted mid-shutdown.
+func (h *HealthAPI) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": CheckOK})
+}
+
+// Readyz checks that storage is writable and the OIDC provider (when
+// configured) is reachable, answering 503 if not or while draining.
+// ?connections=true also probes every bucket connection (admin only); an
+// unreachable connection reports degraded without failing readiness.
+func (h *HealthAPI) Readyz(c *gin.Context) {
+	if h.App.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, readyResponse{Status: ReadyDraining, Checks: map[string]checkResult{}})
+		return
+	}
+
+	withConnections := c.Query("connections") == "true"
+	if withConnections {
+		if _, ok := auth.VerifiedAdminOrRespond(c); !ok {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+	defer cancel()
+
+	res := readyResponse{Status: ReadyOK, Checks: map[string]checkResult{}}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	run := func(name string, check func(ctx context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := timed(func() error { return check(ctx) })
+			mu.Lock()
+			res.Checks[name] = r
+			mu.Unlock()
+		}()
+	}
+
+	run("storage", h.checkStorage)
+	run("oidc", func(ctx context.Context) error {
+		err := h.Auth.CheckProvider(ctx)
+		if errors.Is(err, auth.ErrOIDCNotConfigured) {
+			return errSkipped
+		}
+		return err
+	})
+
+	if withConnections {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conns, err := h.connectionChecks(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				res.Checks["connections"] = checkResult{Status: CheckFail, Error: err.Error()}
+				return
+			}
+			res.Connections = conns
+		}()
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, r := range res.Checks {
+		if r.Status == CheckFail {
+			res.Status, status = ReadyFail, http.StatusServiceUnavailable
+		}
+	}
+	if res.Status == ReadyOK {
+		for _, r := range res.Connections {
+			if r.Status == CheckFail {
+				res.Status = ReadyDegraded
+			}
+		}
+	}
+	c.JSON(status, res)
+}
+
+// --- Checks ---
+
+// errSkipped marks a check that doesn't apply, such as OIDC when it isn't
+// configured.
+var errSkipped = errors.New("skipped")
+
+// checkStorage writes a short-lived key and reads it back. Each replica
+// uses its own key, so probes on a shared backend don't collide.
+func (h *HealthAPI) checkStorage(ctx context.Context) error {
+	host, _ := os.Hostname()
+	key := storage.KeyHealthCheck + host
+
+	done := make(chan error, 1)
+	go func() {
+		want := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+		if err := storage.PutWithTTL(h.App.DB, key, want, time.Minute); err != nil {
+			done <- err
+			return
+		}
+		got, err := storage.Get(h.App.DB, key)
+		if err == nil && !bytes.Equal(got, want) {
+			err = errors.New("read back a different value")
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *HealthAPI) connectionChecks(ctx context.Context) (map[string]checkResult, error) {
+	checks, err := h.Buckets.CheckConnections(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]checkResult, len(checks))
+	for _, ch := range checks {
+		r := checkResult{Status: CheckOK, LatencyMs: ms(ch.Latency)}
+		switch {
+		case errors.Is(ch.Err, buckets.ErrCheckSkipped):
+			r.Status, r.Error = CheckSkipped, ch.Err.Error()
+		case ch.Err != nil:
+			r.Status, r.Error = CheckFail, ch.Err.Error()
+		}
+		out[ch.Bucket] = r
+	}
+	return out, nil
+}
+
+// --- Helpers ---
+
+func timed(check func() error) checkResult {
+	start := time.Now()
+	err := check()
+	r := checkResult{Status: CheckOK, LatencyMs: ms(time.Since(start))}
+	switch {
+	case errors.Is(err, errSkipped):
+		r.Status = CheckSkipped
+	case err != nil:
+		r.Status, r.Error = CheckFail, err.Error()
+	}
+	return r
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -1,13 +1,24 @@
 package app
 
 import (
-	"b0k3ts/configs"
+	"b0k3ts/internal/pkg/audit"
 	"b0k3ts/internal/pkg/auth"
-	badgerDB "b0k3ts/internal/pkg/badger"
+	"b0k3ts/internal/pkg/backup"
 	"b0k3ts/internal/pkg/buckets"
 	"b0k3ts/internal/pkg/kubernetes"
-	"encoding/json"
+	"b0k3ts/internal/pkg/leader"
+	"b0k3ts/internal/pkg/notify"
+	"b0k3ts/internal/pkg/state"
+	"b0k3ts/internal/pkg/storage"
+	"context"
+	"fmt"
 	"log/slog"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,7 +30,8 @@ func (app *App) Serve() {
 
 	r.Use(gin.Recovery())
 	r.Use(gin.LoggerWithConfig(gin.LoggerConfig{
-		SkipPaths: []string{"/api/v1/healthz"},
+		Formatter: logFormatter,
+		SkipPaths: []string{"/api/v1/healthz", "/api/v1/livez", "/api/v1/readyz"},
 	}))
 
 	if err := r.SetTrustedProxies(nil); err != nil {
@@ -28,35 +40,46 @@ func (app *App) Serve() {
 	}
 
 	//
-	var oic configs.OIDC
-
-	res, err := badgerDB.PullKV(app.BadgerDB, "oidc-config")
+	oic, err := auth.LoadOIDCConfig(app.DB)
 	if err != nil {
-		if err.Error() == "Key not found" {
-			slog.Info("OIDC Not Configured")
-		} else {
-			slog.Error(err.Error())
-			return
-		}
-		//return
-
+		slog.Error(err.Error())
+		return
 	}
 
-	if err == nil {
-		err = json.Unmarshal(res, &oic)
-		if err != nil {
-			slog.Error(err.Error())
-			return
-		}
+	// SIGTERM or SIGINT cancels background work and starts draining
+	//
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// Background maintenance runs only on the leader; every replica serves
+	// the API.
+	//
+	elector, err := leader.New(app.Config.HA)
+	if err != nil {
+		slog.Error("failed to set up leader election", "err", err)
+		return
 	}
 
-	oAuth := auth.New(app.Config, oic, app.BadgerDB)
-	bucket := buckets.NewConfig(app.BadgerDB, oic)
-	localStore := auth.NewStore(app.BadgerDB)
+	backupManager := backup.New(app.DB, app.Config.Backup)
+	elector.Go("backups", backupManager.Start)
+	elector.Go("value-log-gc", func(ctx context.Context) {
+		backup.StartValueLogGC(ctx, app.DB, app.Config.ValueLogGC)
+	})
+	elector.Go("storage-janitor", func(ctx context.Context) {
+		storage.RunJanitor(ctx, app.DB)
+	})
+
+	oAuth := auth.New(app.Config, oic, app.DB)
+	r.Use(oAuth.Attach())
+	bucket := buckets.NewConfig(app.DB, oic)
+	localStore := auth.NewStore(app.DB)
+	health := &HealthAPI{App: app, Auth: oAuth, Buckets: bucket}
 
 	v1 := r.Group("/api/v1")
 	{
-		v1.GET("/healthz", HealthzCheck)
+		v1.GET("/healthz", app.HealthzCheck)
+		v1.GET("/livez", health.Livez)
+		v1.GET("/readyz", health.Readyz)
 
 		oidc := v1.Group("/oidc")
 		{
@@ -82,6 +105,29 @@ func (app *App) Serve() {
 			bkt.POST("/add_connection", bucket.AddConnection)
 			bkt.GET("/list_connections", bucket.ListConnection)
 			bkt.POST("/delete_connection", bucket.DeleteConnection)
+
+			// Lifecycle rules (admin only):
+			bkt.POST("/lifecycle/get", bucket.GetLifecycle)
+			bkt.POST("/lifecycle/validate", bucket.ValidateLifecycle)
+			bkt.POST("/lifecycle/set", bucket.SetLifecycle)
+			bkt.POST("/lifecycle/preview", bucket.PreviewLifecycle)
+
+			// Bucket policy (set is admin only and audited):
+			bkt.POST("/policy/get", bucket.GetBucketPolicy)
+			bkt.POST("/policy/lint", bucket.LintBucketPolicy)
+			bkt.POST("/policy/set", bucket.SetBucketPolicy)
+
+			// Bucket notification configuration (set is admin only):
+			bkt.POST("/notifications/get", bucket.GetBucketNotifications)
+			bkt.POST("/notifications/set", bucket.SetBucketNotifications)
+
+			// Endpoint discovery and bucket creation (admin only):
+			bkt.POST("/endpoints/add", bucket.AddEndpoint)
+			bkt.GET("/endpoints/list", bucket.ListEndpoints)
+			bkt.POST("/endpoints/delete", bucket.DeleteEndpoint)
+			bkt.POST("/endpoints/discover", bucket.DiscoverBuckets)
+			bkt.POST("/endpoints/import", bucket.ImportBuckets)
+			bkt.POST("/create", bucket.CreateBucket)
 		}
 
 		objects := v1.Group("/objects")
@@ -90,10 +136,24 @@ func (app *App) Serve() {
 			objects.POST("/download", bucket.Download)
 			objects.GET("/download/:bucket/*key", bucket.DownloadNative)
 			objects.POST("/presign-download", bucket.PresignDownload)
+			objects.POST("/preview", bucket.Preview)
+			objects.POST("/stat", bucket.StatObject)
 
 			objects.POST("/delete", bucket.Delete)
 			objects.POST("/list", bucket.ListObjects)
 			objects.POST("/move", bucket.Move)
+			objects.GET("/events", bucket.ObjectEvents) // Server-Sent Events
+
+			// Object lock / retention / legal hold:
+			objects.POST("/lock/config", bucket.GetObjectLockConfig)
+			objects.POST("/lock/status", bucket.GetObjectLockStatus)
+			objects.POST("/retention/get", bucket.GetObjectRetention)
+			objects.POST("/retention/set", bucket.SetObjectRetention)
+			objects.POST("/legal_hold/set", bucket.SetObjectLegalHold)
+
+			// Archive extraction (runs as a background job):
+			objects.POST("/extract", bucket.ExtractArchive)
+			objects.GET("/extract/:job_id", bucket.ExtractStatus)
 
 			// Direct Multipart Upload:
 			objects.POST("/multipart/initiate", bucket.MultipartInitiate)
@@ -102,23 +162,140 @@ func (app *App) Serve() {
 			objects.POST("/multipart/abort", bucket.MultipartAbort)
 		}
 
+		shares := v1.Group("/shares")
+		{
+			shares.POST("/create", bucket.CreateShare)
+			shares.GET("/list", bucket.ListShares)
+			shares.POST("/revoke", bucket.RevokeShare)
+		}
+
+		uploadRequests := v1.Group("/upload_requests")
+		{
+			uploadRequests.POST("/create", bucket.CreateUploadRequest)
+			uploadRequests.GET("/list", bucket.ListUploadRequests)
+			uploadRequests.POST("/revoke", bucket.RevokeUploadRequest)
+		}
+
+		notifications := v1.Group("/notifications")
+		{
+			notify.RegisterRoutes(notifications, app.DB)
+		}
+
+		auditTrail := v1.Group("/audit")
+		{
+			audit.RegisterRoutes(auditTrail, app.DB)
+		}
+
+		stateBundle := v1.Group("/state")
+		{
+			state.RegisterRoutes(stateBundle, app.DB)
+		}
+
+		backups := v1.Group("/backups")
+		{
+			backup.RegisterRoutes(backups, backupManager)
+		}
+
 		k8s := v1.Group("/kubernetes")
 		{
-			kubernetes.RegisterRoutes(k8s, app.BadgerDB)
+			kubernetes.RegisterRoutes(k8s, app.DB)
 		}
 
 	}
 
-	slog.Info("listening on " + app.Config.Host + ":" + app.Config.Port)
+	// Public share links (no Authorization header; token, expiry and optional password instead)
+	r.GET("/s/:token", bucket.ServeShare)
+	r.POST("/s/:token", bucket.ServeShare)
+
+	// Public upload-only links, restricted to the link's prefix
+	dropbox := r.Group("/d/:token")
+	{
+		dropbox.GET("", bucket.DropboxInfo)
+		dropbox.POST("/multipart/initiate", bucket.DropboxInitiate)
+		dropbox.POST("/multipart/presign_part", bucket.DropboxPresignPart)
+		dropbox.POST("/multipart/complete", bucket.DropboxComplete)
+		dropbox.POST("/multipart/abort", bucket.DropboxAbort)
+	}
 
-	// Run the server
+	// Run the server until a signal, then drain
 	//
-	err = r.Run(app.Config.Host + ":" + app.Config.Port)
+	srv, err := app.newServer(ctx, r)
+	if err != nil {
+		slog.Error("failed to run server", "err", err)
+		app.closeStorage()
+		return
+	}
+	srv.srv.RegisterOnShutdown(buckets.CloseEventStreams)
+
+	err = elector.Start(ctx)
 	if err != nil {
-		slog.Error("failed to run gin router: ", err)
+		slog.Error("failed to start leader election", "err", err)
+		app.closeStorage()
 		return
 	}
 
-	return
+	// Replicas relay object events to each other's event streams
+	//
+	if app.Config.HA.Enabled {
+		err = buckets.StartEventRelay(ctx, app.DB)
+		if err != nil {
+			slog.Error("failed to start object event relay", "err", err)
+			app.closeStorage()
+			return
+		}
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- srv.serve() }()
+
+	select {
+	case err = <-served:
+		slog.Error("failed to run server", "err", err)
+		stop()
+		app.stopWork(elector, bucket)
+	case <-ctx.Done():
+		stop() // a second signal exits at once
+		app.shutdown(srv, elector, bucket)
+	}
+
+}
+
+// logFormatter is gin's default access log line with credentials passed in
+// the query (the event stream's access_token) masked.
+func logFormatter(param gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		redactQuery(param.Path),
+		param.ErrorMessage,
+	)
+}
 
+func redactQuery(path string) string {
+	p, rawQuery, ok := strings.Cut(path, "?")
+	if !ok {
+		return path
+	}
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return p + "?(unparsable query)"
+	}
+	if !q.Has("access_token") {
+		return path
+	}
+	q.Set("access_token", "REDACTED")
+	return p + "?" + q.Encode()
 }
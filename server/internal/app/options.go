@@ -0,0 +1,239 @@
+package app
+
+import (
+	"b0k3ts/internal/pkg/storage"
+	"b0k3ts/internal/pkg/tlsreload"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Settings can come from flags, B0K3TS_* environment variables or
+// config.yaml. The first one set wins, in that order, then the default.
+const (
+	EnvConfig    = "B0K3TS_CONFIG"
+	EnvDataDir   = "B0K3TS_DATA_DIR"
+	EnvHost      = "B0K3TS_HOST"
+	EnvPort      = "B0K3TS_PORT"
+	EnvLogLevel  = "B0K3TS_LOG_LEVEL"
+	EnvJWTSecret = "B0K3TS_JWT_SECRET"
+
+	defaultConfigPath = "config.yaml"
+	defaultDataDir    = "/opt/b0k3ts/data"
+	defaultHost       = "0.0.0.0"
+	defaultPort       = "8080"
+	defaultLogLevel   = "debug"
+)
+
+// Options holds the command-line flags. Empty means the flag wasn't given.
+type Options struct {
+	ConfigPath string
+	DataDir    string
+	Host       string
+	Port       string
+	LogLevel   string
+	JWTSecret  string
+}
+
+// ParseOptions parses the server's flags. -h prints usage and returns
+// flag.ErrHelp.
+func ParseOptions(args []string) (Options, error) {
+	fs := flag.NewFlagSet("b0k3ts", flag.ContinueOnError)
+	var opts Options
+	opts.register(fs)
+	fs.StringVar(&opts.Host, "host", "", "listen address (env "+EnvHost+", config host, default "+defaultHost+")")
+	fs.StringVar(&opts.Port, "port", "", "listen port (env "+EnvPort+", config port, default "+defaultPort+")")
+	fs.StringVar(&opts.LogLevel, "log-level", "", "debug, info, warn or error (env "+EnvLogLevel+", config logLevel, default "+defaultLogLevel+")")
+	fs.StringVar(&opts.JWTSecret, "jwt-secret", "", "secret for signing local tokens (env "+EnvJWTSecret+", config jwtSecret); prefer the env var")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: b0k3ts [flags]\n       b0k3ts export|import [flags]\n\nFlags:")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return Options{}, err
+	}
+	if fs.NArg() > 0 {
+		err := fmt.Errorf("unexpected argument %q", fs.Arg(0))
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return Options{}, err
+	}
+	return opts, nil
+}
+
+// register adds the flags the export and import subcommands share with the
+// server.
+func (o *Options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.ConfigPath, "config", "", "path to config.yaml (env "+EnvConfig+", default ./"+defaultConfigPath+")")
+	fs.StringVar(&o.DataDir, "data-dir", "", "Badger data directory (env "+EnvDataDir+", config storage.badger.directory, default "+defaultDataDir+")")
+}
+
+// --- Resolution ---
+
+// setting is one resolved value and where it came from, for the startup
+// report.
+type setting struct {
+	name   string
+	value  string
+	source string
+	secret bool
+}
+
+// pick returns the first non-empty of flag, env and config, else def.
+func pick(name, flagValue, env, configValue, def string) setting {
+	if flagValue != "" {
+		return setting{name: name, value: flagValue, source: "flag"}
+	}
+	if v := os.Getenv(env); v != "" {
+		return setting{name: name, value: v, source: "env " + env}
+	}
+	if configValue != "" {
+		return setting{name: name, value: configValue, source: "config"}
+	}
+	return setting{name: name, value: def, source: "default"}
+}
+
+// configPath returns the config file to read and whether it was asked for
+// explicitly; only then is a missing file an error.
+func (o Options) configPath() (setting, bool) {
+	s := pick("config", o.ConfigPath, EnvConfig, "", defaultConfigPath)
+	return s, s.source != "default"
+}
+
+// resolve applies flags and environment variables over config.yaml and
+// fills in defaults. It returns every setting for the report, starting with
+// the config file it was given.
+func (app *App) resolve(configFile setting) []setting {
+	cfg := &app.Config
+	o := app.Options
+
+	host := pick("host", o.Host, EnvHost, cfg.Host, defaultHost)
+	port := pick("port", o.Port, EnvPort, cfg.Port, defaultPort)
+	logLevel := pick("logLevel", o.LogLevel, EnvLogLevel, cfg.LogLevel, defaultLogLevel)
+	jwtSecret := pick("jwtSecret", o.JWTSecret, EnvJWTSecret, cfg.JWTSecret, "")
+	jwtSecret.secret = true
+
+	cfg.Host, cfg.Port, cfg.LogLevel, cfg.JWTSecret = host.value, port.value, logLevel.value, jwtSecret.value
+
+	settings := []setting{configFile, host, port, logLevel, jwtSecret}
+
+	tlsMode := "off"
+	if cfg.TLS.CertFile != "" {
+		tlsMode = cfg.TLS.CertFile
+		if cfg.TLS.ClientCAFile != "" {
+			tlsMode += " (client CA " + cfg.TLS.ClientCAFile + ")"
+		}
+	}
+	settings = append(settings, setting{name: "tls", value: tlsMode, source: "config"})
+
+	backend := cfg.Storage.Backend
+	if backend == "" {
+		backend = storage.BackendBadger
+	}
+	settings = append(settings, setting{name: "storage.backend", value: backend, source: "config"})
+	if backend == storage.BackendBadger {
+		dataDir := pick("dataDir", o.DataDir, EnvDataDir, cfg.Storage.Badger.Directory, defaultDataDir)
+		cfg.Storage.Badger.Directory = dataDir.value
+		settings = append(settings, dataDir)
+	}
+	return settings
+}
+
+// validate checks the resolved configuration and returns every problem
+// found, so they can all be fixed at once.
+func (app *App) validate() []error {
+	cfg := app.Config
+	var problems []error
+
+	if strings.TrimSpace(cfg.Host) == "" {
+		problems = append(problems, errors.New("host is empty"))
+	}
+	if p, err := strconv.Atoi(cfg.Port); err != nil || p < 1 || p > 65535 {
+		problems = append(problems, fmt.Errorf("port %q must be a number from 1 to 65535", cfg.Port))
+	}
+	if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+		problems = append(problems, err)
+	}
+	if strings.TrimSpace(cfg.JWTSecret) == "" {
+		problems = append(problems, fmt.Errorf("jwtSecret is required (set %s or jwtSecret in config.yaml)", EnvJWTSecret))
+	}
+
+	if cfg.TLS.CertFile != "" || cfg.TLS.KeyFile != "" {
+		if _, err := tlsreload.New(cfg.TLS); err != nil {
+			problems = append(problems, err)
+		}
+	} else if cfg.TLS.RedirectFrom != "" || cfg.TLS.ClientCAFile != "" {
+		problems = append(problems, errors.New("tls.redirectFrom and tls.clientCAFile need tls.certFile and tls.keyFile"))
+	}
+
+	switch cfg.Storage.Backend {
+	case "", storage.BackendBadger:
+		if err := checkWritableDir(cfg.Storage.Badger.Directory); err != nil {
+			problems = append(problems, fmt.Errorf("dataDir: %w", err))
+		}
+	case storage.BackendPostgres:
+		if _, err := cfg.Storage.Postgres.DSN.Resolve(); err != nil {
+			problems = append(problems, fmt.Errorf("storage.postgres.dsn: %w", err))
+		}
+	default:
+		problems = append(problems, fmt.Errorf("storage.backend %q must be %s or %s", cfg.Storage.Backend, storage.BackendBadger, storage.BackendPostgres))
+	}
+
+	// Replicas share state only through the storage backend, and Badger
+	// can't be shared.
+	if cfg.HA.Enabled && cfg.Storage.Backend != storage.BackendPostgres {
+		problems = append(problems, fmt.Errorf("ha.enabled requires storage.backend %s", storage.BackendPostgres))
+	}
+	return problems
+}
+
+// report logs every resolved setting and its source, with secrets masked.
+func report(settings []setting) {
+	for _, s := range settings {
+		value := s.value
+		if s.secret {
+			value = "(unset)"
+			if s.value != "" {
+				value = "(set)"
+			}
+		}
+		slog.Info("configuration", "setting", s.name, "value", value, "source", s.source)
+	}
+}
+
+// --- Helpers ---
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("logLevel %q must be debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// checkWritableDir creates dir if needed and makes sure files can be
+// created in it.
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
+
+// setupLogger installs the default logger at the resolved level.
+func setupLogger(level slog.Level) {
+	handlerOptions := &slog.HandlerOptions{
+		Level: level,
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, handlerOptions)))
+}
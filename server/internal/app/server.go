@@ -0,0 +1,208 @@
+package app
+
+import (
+	"b0k3ts/internal/pkg/buckets"
+	"b0k3ts/internal/pkg/leader"
+	"b0k3ts/internal/pkg/tlsreload"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultReadyDelay   = 5 * time.Second
+	defaultDrainTimeout = 20 * time.Second
+	defaultWorkTimeout  = 5 * time.Second
+)
+
+// httpServer is the API listener plus the optional plain-HTTP listener that
+// redirects to it.
+type httpServer struct {
+	srv      *http.Server
+	ln       net.Listener
+	tls      bool
+	clientCA bool
+
+	redirect   *http.Server
+	redirectLn net.Listener
+}
+
+// newServer binds the configured address, so a port already in use fails
+// before anything is served. With a certificate configured it serves HTTPS,
+// reloading the certificate as it changes until ctx is done.
+func (app *App) newServer(ctx context.Context, handler http.Handler) (*httpServer, error) {
+	addr := net.JoinHostPort(app.Config.Host, app.Config.Port)
+	cfg := app.Config.TLS
+
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+
+	s := &httpServer{
+		srv: &http.Server{
+			Addr:      addr,
+			Handler:   handler,
+			Protocols: &protocols,
+		},
+		tls:      cfg.CertFile != "",
+		clientCA: cfg.ClientCAFile != "",
+	}
+
+	if s.tls {
+		reloader, err := tlsreload.New(cfg)
+		if err != nil {
+			return nil, err
+		}
+		go reloader.Watch(ctx)
+		s.srv.TLSConfig = reloader.TLSConfig()
+	} else {
+		// Cleartext HTTP/2 (prior knowledge only), e.g. from an ingress.
+		protocols.SetUnencryptedHTTP2(true)
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.ln = ln
+
+	if s.tls && cfg.RedirectFrom != "" {
+		redirectLn, err := net.Listen("tcp", cfg.RedirectFrom)
+		if err != nil {
+			_ = ln.Close()
+			return nil, err
+		}
+		s.redirectLn = redirectLn
+		s.redirect = &http.Server{Handler: redirectToHTTPS(app.Config.Port)}
+	}
+	return s, nil
+}
+
+// serve runs until the listener fails or shutdown is called; the latter
+// returns nil.
+func (s *httpServer) serve() error {
+	if s.redirect != nil {
+		slog.Info("redirecting http to https", "from", s.redirectLn.Addr().String())
+		go func() {
+			if err := s.redirect.Serve(s.redirectLn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("https redirect listener stopped", "err", err)
+			}
+		}()
+	}
+
+	slog.Info("listening on "+s.srv.Addr, "tls", s.tls, "clientCA", s.clientCA)
+
+	var err error
+	if s.tls {
+		err = s.srv.ServeTLS(s.ln, "", "")
+	} else {
+		err = s.srv.Serve(s.ln)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// shutdown stops accepting connections and waits for in-flight requests
+// until ctx is done.
+func (s *httpServer) shutdown(ctx context.Context) error {
+	if s.redirect != nil {
+		_ = s.redirect.Shutdown(ctx)
+	}
+	return s.srv.Shutdown(ctx)
+}
+
+// close drops the connections still open after shutdown timed out.
+func (s *httpServer) close() {
+	if s.redirect != nil {
+		_ = s.redirect.Close()
+	}
+	_ = s.srv.Close()
+}
+
+// --- Shutdown ---
+
+// shutdown drains the server after SIGTERM or SIGINT. /healthz turns
+// not-ready at once, so load balancers stop sending traffic; after the ready
+// delay the listener closes and in-flight requests get the drain timeout to
+// finish. Then background work is stopped and storage closed.
+func (app *App) shutdown(s *httpServer, elector *leader.Elector, bucket *buckets.App) {
+	readyDelay := app.Config.Shutdown.ReadyDelay
+	if readyDelay <= 0 {
+		readyDelay = defaultReadyDelay
+	}
+
+	app.draining.Store(true)
+	slog.Info("shutting down", "readyDelay", readyDelay, "drainTimeout", app.drainTimeout(), "workTimeout", app.workTimeout())
+	time.Sleep(readyDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), app.drainTimeout())
+	defer cancel()
+
+	if err := s.shutdown(ctx); err != nil {
+		slog.Warn("drain timed out, closing remaining connections", "err", err)
+		s.close()
+	}
+
+	app.stopWork(elector, bucket)
+	slog.Info("shutdown complete")
+}
+
+// stopWork gives extraction jobs and leader tasks their own workTimeout,
+// since the drain may have used up its deadline. Storage is only closed once
+// nothing can write to it any more.
+func (app *App) stopWork(elector *leader.Elector, bucket *buckets.App) {
+	ctx, cancel := context.WithTimeout(context.Background(), app.workTimeout())
+	defer cancel()
+
+	bucket.StopJobs(ctx)
+	if err := elector.Wait(ctx); err != nil {
+		slog.Warn("background work did not stop in time, leaving storage open", "err", err)
+		return
+	}
+	app.closeStorage()
+}
+
+func (app *App) drainTimeout() time.Duration {
+	if d := app.Config.Shutdown.DrainTimeout; d > 0 {
+		return d
+	}
+	return defaultDrainTimeout
+}
+
+func (app *App) workTimeout() time.Duration {
+	if d := app.Config.Shutdown.WorkTimeout; d > 0 {
+		return d
+	}
+	return defaultWorkTimeout
+}
+
+// closeStorage flushes and closes the storage backend.
+func (app *App) closeStorage() {
+	if err := app.DB.Close(); err != nil {
+		slog.Error("failed to close storage", "err", err)
+	}
+}
+
+// redirectToHTTPS sends every request to the same host and path on the
+// HTTPS port. 308 keeps the method and body.
+func redirectToHTTPS(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"b0k3ts/internal/pkg/state"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// RunStateCommand runs the export and import subcommands and returns the
+// process exit code. They open the storage configured in config.yaml
+// directly, so with Badger the server must be stopped first. The bundle
+// passphrase comes from B0K3TS_STATE_PASSPHRASE.
+func RunStateCommand(name string, args []string) int {
+	switch name {
+	case "export":
+		return runExport(args)
+	case "import":
+		return runImport(args)
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+	return 2
+}
+
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := fs.String("o", "-", "write the bundle to this file (- for stdout)")
+	var opts Options
+	opts.register(fs)
+	sections := fs.String("sections", "", "comma-separated sections to export (default: all of "+strings.Join(state.SectionNames(), ", ")+")")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var names []string
+	if *sections != "" {
+		names = strings.Split(*sections, ",")
+	}
+
+	app, err := openForCommand(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export failed:", err)
+		return 1
+	}
+	defer app.DB.Close()
+
+	b, err := state.Export(app.DB, names)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export failed:", err)
+		return 1
+	}
+	data, err := state.Seal(b, os.Getenv(state.PassphraseEnv))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export failed:", err)
+		return 1
+	}
+
+	if *out == "-" {
+		_, err = os.Stdout.Write(append(data, '\n'))
+	} else {
+		err = os.WriteFile(*out, data, 0o600)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export failed:", err)
+		return 1
+	}
+	return 0
+}
+
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	in := fs.String("f", "-", "read the bundle from this file (- for stdin)")
+	mode := fs.String("mode", state.ModeMerge, "merge or replace")
+	dryRun := fs.Bool("dry-run", false, "print the changes without applying them")
+	var opts Options
+	opts.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if *in == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*in)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import failed:", err)
+		return 1
+	}
+
+	b, err := state.Open(data, os.Getenv(state.PassphraseEnv))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import failed:", err)
+		return 1
+	}
+
+	app, err := openForCommand(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import failed:", err)
+		return 1
+	}
+	defer app.DB.Close()
+
+	plan, err := state.Import(app.DB, b, *mode, *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import failed:", err)
+		return 1
+	}
+
+	res, _ := json.MarshalIndent(plan, "", "  ")
+	fmt.Println(string(res))
+	return 0
+}
+
+// openForCommand opens storage for a subcommand, using the config file and
+// data directory overrides the server would.
+func openForCommand(opts Options) (*App, error) {
+	app := New(opts)
+	if _, _, err := app.loadConfig(); err != nil {
+		return nil, err
+	}
+	return app, app.openStorage()
+}
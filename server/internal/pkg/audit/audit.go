@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"b0k3ts/internal/pkg/auth"
+	"b0k3ts/internal/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Constants / Types ---
+
+const (
+	auditKeyPrefix = storage.NSAudit
+
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+)
+
+// Event is one audit trail entry. Events are append-only and never expire.
+type Event struct {
+	Id        string            `json:"id"`
+	Actor     string            `json:"actor"`  // user email
+	Action    string            `json:"action"` // e.g. "bucket_policy_set"
+	Target    string            `json:"target"` // e.g. bucket connection id
+	Details   map[string]string `json:"details,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// --- Public: Route registration ---
+
+// RegisterRoutes mounts the admin-only audit trail APIs.
+// Recommended mount point: /api/v1/audit
+func RegisterRoutes(rg *gin.RouterGroup, db storage.Store) {
+	h := &handler{db: db}
+
+	rg.GET("/list", h.List)
+}
+
+type handler struct {
+	db storage.Store
+}
+
+// --- Store ---
+
+// Record appends an event to the audit trail.
+func Record(db storage.Store, actor, action, target string, details map[string]string) error {
+	if db == nil {
+		return errors.New("audit record failed. storage is nil")
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	e := Event{
+		// Time-ordered ids keep keys sorted in creation order.
+		Id:        fmt.Sprintf("%020d-%s", now.UnixNano(), hex.EncodeToString(b)),
+		Actor:     actor,
+		Action:    action,
+		Target:    target,
+		Details:   details,
+		CreatedAt: now,
+	}
+
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("audit", "actor", actor, "action", action, "target", target)
+	return storage.Put(db, auditKeyPrefix+e.Id, raw)
+}
+
+// List returns up to limit events, newest first, optionally filtered by
+// action and target.
+func List(db storage.Store, action, target string, limit int) ([]Event, error) {
+	out := make([]Event, 0)
+	if limit <= 0 {
+		return out, nil
+	}
+
+	err := db.View(func(tx storage.Tx) error {
+		return tx.Scan(auditKeyPrefix, storage.ScanOptions{Reverse: true}, func(item storage.Item) error {
+			var e Event
+			if err := json.Unmarshal(item.Value, &e); err != nil {
+				return err
+			}
+			if action != "" && e.Action != action {
+				return nil
+			}
+			if target != "" && e.Target != target {
+				return nil
+			}
+			out = append(out, e)
+			if len(out) == limit {
+				return storage.ErrStopScan
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// --- Gin handlers ---
+
+func (h *handler) List(c *gin.Context) {
+	if _, ok := auth.VerifiedAdminOrRespond(c); !ok {
+		return
+	}
+
+	limit := auditDefaultLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, auditMaxLimit)
+	}
+
+	items, err := List(h.db, c.Query("action"), c.Query("target"), limit)
+	if err != nil {
+		slog.Error("failed to list audit events", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list audit events"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+const contextKey = "b0k3ts.auth"
+
+// Attach makes auth available to VerifiedUser and VerifiedAdminOrRespond
+// in every handler after it.
+func (auth *Auth) Attach() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(contextKey, auth)
+		c.Next()
+	}
+}
+
+// VerifiedUser returns the caller once the bearer token's signature has
+// been checked with VerifyToken.
+func VerifiedUser(c *gin.Context) (User, error) {
+	auth, ok := c.Value(contextKey).(*Auth)
+	if !ok {
+		return User{}, errors.New("token verification is not set up")
+	}
+	return auth.VerifyToken(c.GetHeader("Authorization"))
+}
+
+// VerifiedAdminOrRespond returns the caller if the bearer token is valid
+// and names an administrator, and otherwise responds 401 or 403.
+func VerifiedAdminOrRespond(c *gin.Context) (User, bool) {
+	userInfo, err := VerifiedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return User{}, false
+	}
+	if strings.TrimSpace(userInfo.Email) == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing user"})
+		return User{}, false
+	}
+	if !userInfo.Administrator {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin required"})
+		return User{}, false
+	}
+	return userInfo, true
+}
+
+// VerifyToken checks a bearer token's signature and expiry and returns its
+// user. HS256 tokens are local logins signed with the server's JWT secret;
+// anything else must be an ID token from the configured OIDC provider.
+func (auth *Auth) VerifyToken(authToken string) (User, error) {
+	raw := strings.TrimSpace(authToken)
+	if parts := strings.SplitN(raw, " ", 2); len(parts) == 2 && strings.EqualFold(parts[0], "Bearer") {
+		raw = strings.TrimSpace(parts[1])
+	}
+	if raw == "" {
+		return User{}, errors.New("empty token")
+	}
+
+	tok, _, err := new(jwt.Parser).ParseUnverified(raw, jwt.MapClaims{})
+	if err != nil {
+		return User{}, err
+	}
+
+	if tok.Method == jwt.SigningMethodHS256 {
+		secret := strings.TrimSpace(auth.ServerConfig.JWTSecret)
+		if secret == "" {
+			return User{}, errors.New(JWRErr)
+		}
+		if _, err := parseLocalToken(raw, secret); err != nil {
+			return User{}, err
+		}
+	} else {
+		if auth.OIDCConfig.ProviderUrl == "" {
+			return User{}, ErrOIDCNotConfigured
+		}
+		if _, err := VerifyIDToken(auth.OIDCConfig.ProviderUrl, raw); err != nil {
+			return User{}, err
+		}
+	}
+
+	return TokenToUserData(raw)
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 	"time"
@@ -34,12 +35,12 @@ const JWRErr = "server jwt secret is not configured"
 func (auth *Auth) bindAndValidateLocalUser(c *gin.Context) (*UserRecord, bool) {
 	var req LocalLoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		slog.Error("failed to bind json: ", err)
+		slog.Error("failed to bind json", "err", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return nil, false
 	}
 
-	store := NewStore(auth.BadgerDB)
+	store := NewStore(auth.DB)
 	rec, err := store.ValidateUser(req.Username, req.Password)
 	if err != nil {
 		// Keep this generic to avoid user enumeration.
@@ -93,7 +94,7 @@ func (auth *Auth) LocalLogin(c *gin.Context) {
 
 	signed, err := auth.signLocalJWT(rec)
 	if err != nil {
-		slog.Error("failed to sign token: ", err)
+		slog.Error("failed to sign token", "err", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign token"})
 		return
 	}
@@ -117,7 +118,7 @@ func (auth *Auth) LocalLoginRedirect(c *gin.Context) {
 
 	signed, err := auth.signLocalJWT(rec)
 	if err != nil {
-		slog.Error("failed to sign token: ", err)
+		slog.Error("failed to sign token", "err", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign token"})
 		return
 	}
@@ -151,14 +152,8 @@ func (auth *Auth) LocalAuthorize(c *gin.Context) {
 		return
 	}
 
-	claims := &LocalClaims{}
-	parsed, err := jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
-		if jwt.SigningMethodHS256 != token.Method {
-			return nil, ErrInvalidUsernameOrPassword
-		}
-		return []byte(secret), nil
-	})
-	if err != nil || !parsed.Valid {
+	claims, err := parseLocalToken(rawToken, secret)
+	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 		return
 	}
@@ -172,3 +167,21 @@ func (auth *Auth) LocalAuthorize(c *gin.Context) {
 		},
 	})
 }
+
+// parseLocalToken verifies a local login token signed with secret.
+func parseLocalToken(rawToken, secret string) (*LocalClaims, error) {
+	claims := &LocalClaims{}
+	parsed, err := jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if jwt.SigningMethodHS256 != token.Method {
+			return nil, ErrInvalidUsernameOrPassword
+		}
+		return []byte(secret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !parsed.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
@@ -1,14 +1,12 @@
 package auth
 
 import (
-	badgerKV "b0k3ts/internal/pkg/badger"
-	"encoding/json"
+	"b0k3ts/internal/pkg/storage"
 	"errors"
 	"fmt"
 	"strings"
 	"time"
 
-	"github.com/dgraph-io/badger/v4"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -32,14 +30,14 @@ type UserRecord struct {
 }
 
 type Store struct {
-	DB *badger.DB
+	DB storage.Store
 
 	// Optional tuning:
 	// bcrypt.DefaultCost is usually fine; 12 is a common choice.
 	BcryptCost int
 }
 
-func NewStore(db *badger.DB) *Store {
+func NewStore(db storage.Store) *Store {
 	return &Store{
 		DB:         db,
 		BcryptCost: bcrypt.DefaultCost,
@@ -63,12 +61,7 @@ func (s *Store) hashPassword(password string) (string, error) {
 
 func (s *Store) putUserRecord(rec *UserRecord) error {
 	rec.UpdatedAt = time.Now().UTC()
-
-	b, err := json.Marshal(rec)
-	if err != nil {
-		return fmt.Errorf(MarshalUpdatedUserRecord+": %w", err)
-	}
-	return badgerKV.PutKV(s.DB, userKey(rec.Username), b)
+	return s.users().Put(rec.Username, *rec)
 }
 
 func (s *Store) UserExists(username string) (bool, error) {
@@ -77,14 +70,7 @@ func (s *Store) UserExists(username string) (bool, error) {
 		return false, errors.New(UsernameRequired)
 	}
 
-	_, err := badgerKV.PullKV(s.DB, userKey(username))
-	if err == nil {
-		return true, nil
-	}
-	if isKeyNotFound(err) {
-		return false, nil
-	}
-	return false, err
+	return s.users().Exists(username)
 }
 
 // EnsureUser creates the user only if it does not exist yet.
@@ -140,12 +126,7 @@ func (s *Store) CreateUser(username, password string, administrator bool) error
 		Administrator: administrator,
 	}
 
-	b, err := json.Marshal(rec)
-	if err != nil {
-		return fmt.Errorf("marshal user record: %w", err)
-	}
-
-	return badgerKV.PutKV(s.DB, userKey(username), b)
+	return s.users().Put(username, rec)
 }
 
 // GetUser fetches a user record (including hash). Do not return the hash to clients.
@@ -155,18 +136,12 @@ func (s *Store) GetUser(username string) (*UserRecord, error) {
 		return nil, errors.New(UsernameRequired)
 	}
 
-	b, err := badgerKV.PullKV(s.DB, userKey(username))
+	rec, err := s.users().Get(username)
 	if err != nil {
-		// Badger returns an error when key not found; treat that as user-not-found.
-		if isKeyNotFound(err) {
+		if storage.IsNotFound(err) {
 			return nil, ErrUserNotFound
 		}
-		return nil, err
-	}
-
-	var rec UserRecord
-	if err := json.Unmarshal(b, &rec); err != nil {
-		return nil, fmt.Errorf("unmarshal user record: %w", err)
+		return nil, fmt.Errorf("get user record: %w", err)
 	}
 
 	return &rec, nil
@@ -227,13 +202,7 @@ func (s *Store) DisableUser(username string, disabled bool) error {
 		return err
 	}
 	rec.Disabled = disabled
-	rec.UpdatedAt = time.Now().UTC()
-
-	b, err := json.Marshal(rec)
-	if err != nil {
-		return fmt.Errorf(MarshalUpdatedUserRecord+": %w", err)
-	}
-	return badgerKV.PutKV(s.DB, userKey(username), b)
+	return s.putUserRecord(rec)
 }
 
 func (s *Store) DeleteUser(username string) error {
@@ -241,11 +210,15 @@ func (s *Store) DeleteUser(username string) error {
 	if username == "" {
 		return errors.New(UsernameRequired)
 	}
-	return badgerKV.DeleteKV(s.DB, userKey(username))
+	return s.users().Delete(username)
 }
 
-func userKey(username string) string {
-	return "local_users/" + username
+// UserKeyPrefix is the storage key prefix for local user records.
+const UserKeyPrefix = storage.NSUsers
+
+// users stores local user records keyed by normalized username.
+func (s *Store) users() storage.Repository[UserRecord] {
+	return storage.NewRepository[UserRecord](s.DB, UserKeyPrefix)
 }
 
 func normalizeUsername(u string) string {
@@ -254,11 +227,6 @@ func normalizeUsername(u string) string {
 	return u
 }
 
-// Badger's not-found error is badger.ErrKeyNotFound.
-func isKeyNotFound(err error) bool {
-	return errors.Is(err, badger.ErrKeyNotFound)
-}
-
 // UpdatePassword sets a new password for the given user (without requiring the old password).
 // Useful for admin resets or "forgot password" flows.
 func (s *Store) UpdatePassword(username, newPassword string) error {
@@ -2,32 +2,31 @@ package auth
 
 import (
 	"b0k3ts/configs"
-	badgerDB "b0k3ts/internal/pkg/badger"
+	"b0k3ts/internal/pkg/storage"
 	"context"
 	"crypto/tls"
-	"encoding/json"
 	"errors"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"log/slog"
 
 	"github.com/coreos/go-oidc"
-	"github.com/dgraph-io/badger/v4"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
 
 	"golang.org/x/oauth2"
 )
 
-const OIDCConfigVar = "oidc-config"
+const OIDCConfigVar = storage.KeyOIDCConfig
 
 type Auth struct {
 	ServerConfig configs.ServerConfig
 	OIDCConfig   configs.OIDC
-	BadgerDB     *badger.DB
+	DB           storage.Store
 }
 
 type User struct {
@@ -62,24 +61,35 @@ type Claims struct {
 	Groups        []string `json:"groups"`
 }
 
-func New(config configs.ServerConfig, oidcConfig configs.OIDC, db *badger.DB) *Auth {
+// LoadOIDCConfig returns the stored OIDC settings; an unconfigured server
+// gets the zero value.
+func LoadOIDCConfig(db storage.Store) (configs.OIDC, error) {
+	oic, err := storage.Settings[configs.OIDC](db).Get(storage.SettingOIDC)
+	if storage.IsNotFound(err) {
+		slog.Info("OIDC Not Configured")
+		return configs.OIDC{}, nil
+	}
+	return oic, err
+}
+
+func New(config configs.ServerConfig, oidcConfig configs.OIDC, db storage.Store) *Auth {
 	return &Auth{
 		ServerConfig: config,
 		OIDCConfig:   oidcConfig,
-		BadgerDB:     db,
+		DB:           db,
 	}
 }
 
 func (auth *Auth) GetConfig(c *gin.Context) {
 
-	ret, err := badgerDB.PullKV(auth.BadgerDB, OIDCConfigVar)
+	ret, err := storage.Settings[configs.OIDC](auth.DB).Get(storage.SettingOIDC)
 	if err != nil {
 		slog.Error(err.Error())
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.Data(200, "application/json", ret)
+	c.JSON(200, ret)
 
 }
 
@@ -87,46 +97,23 @@ func (auth *Auth) Configure(c *gin.Context) {
 
 	var req configs.OIDC
 	if err := c.ShouldBindJSON(&req); err != nil {
-		slog.Error("failed to bind json: ", err)
+		slog.Error("failed to bind json", "err", err)
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	ret, err := json.Marshal(req)
-	if err != nil {
-		slog.Error("failed to marshal json: ", err)
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Saving Config on Badger
+	// Saving Config to Storage
 	//
-	err = badgerDB.PutKV(auth.BadgerDB, OIDCConfigVar, ret)
+	err := storage.Settings[configs.OIDC](auth.DB).Put(storage.SettingOIDC, req)
 	if err != nil {
 		slog.Error(err.Error())
 		os.Exit(1)
 	}
 
-	var oic configs.OIDC
-
-	res, err := badgerDB.PullKV(auth.BadgerDB, OIDCConfigVar)
+	oic, err := LoadOIDCConfig(auth.DB)
 	if err != nil {
-		if err.Error() == "Key not found" {
-			slog.Info("OIDC Not Configured")
-		} else {
-			slog.Error(err.Error())
-			return
-		}
-		//return
-
-	}
-
-	if err == nil {
-		err = json.Unmarshal(res, &oic)
-		if err != nil {
-			slog.Error(err.Error())
-			return
-		}
+		slog.Error(err.Error())
+		return
 	}
 
 	auth.OIDCConfig = oic
@@ -135,6 +122,28 @@ func (auth *Auth) Configure(c *gin.Context) {
 
 }
 
+// ErrOIDCNotConfigured is returned by CheckProvider when no provider is set.
+var ErrOIDCNotConfigured = errors.New("oidc is not configured")
+
+// CheckProvider fetches the provider's discovery document, to tell whether
+// logins can work.
+func (auth *Auth) CheckProvider(ctx context.Context) error {
+	providerURL := auth.OIDCConfig.ProviderUrl
+	if providerURL == "" {
+		return ErrOIDCNotConfigured
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	_, err := oidc.NewProvider(oidc.ClientContext(ctx, client), providerURL)
+	return err
+}
+
 func (auth *Auth) Login(c *gin.Context) {
 
 	slog.Info("Connecting to OIDC Provider")
@@ -150,7 +159,7 @@ func (auth *Auth) Login(c *gin.Context) {
 		Transport: tr,
 	}
 
-	slog.Debug("%s", auth.OIDCConfig)
+	slog.Debug("oidc config", "provider", auth.OIDCConfig.ProviderUrl)
 
 	ctx := oidc.ClientContext(context.Background(), client)
 	provider, err := oidc.NewProvider(ctx, auth.OIDCConfig.ProviderUrl)
@@ -240,7 +249,7 @@ func (auth *Auth) Callback(c *gin.Context) {
 
 	if err := id.Claims(&claims); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
-		slog.Error("Failed to retrieve claims: ", err)
+		slog.Error("Failed to retrieve claims", "err", err)
 		return
 	}
 
@@ -361,9 +370,20 @@ func extractStringSlice(v interface{}) []string {
 
 // validateOIDC function used to validate users logged in using OIDC
 func (auth *Auth) validateOIDC(authToken string) error {
+	_, err := VerifyIDToken(auth.OIDCConfig.ProviderUrl, authToken)
+	return err
+}
+
+// idTokenVerifiers caches one verifier per provider URL, so discovery and
+// the provider's signing keys aren't fetched again for every request.
+var idTokenVerifiers sync.Map // providerURL -> *oidc.IDTokenVerifier
+
+// VerifyIDToken checks an OIDC token's signature and expiry against the
+// provider and returns the verified token.
+func VerifyIDToken(providerURL, authToken string) (*oidc.IDToken, error) {
 
 	rawAccessToken := authToken
-	realmConfigURL := auth.OIDCConfig.ProviderUrl
+	realmConfigURL := providerURL
 
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
@@ -374,9 +394,13 @@ func (auth *Auth) validateOIDC(authToken string) error {
 	}
 
 	ctx := oidc.ClientContext(context.Background(), client)
+	if v, ok := idTokenVerifiers.Load(realmConfigURL); ok {
+		return v.(*oidc.IDTokenVerifier).Verify(ctx, rawAccessToken)
+	}
+
 	provider, err := oidc.NewProvider(ctx, realmConfigURL)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	oidcConfig := &oidc.Config{
@@ -384,12 +408,8 @@ func (auth *Auth) validateOIDC(authToken string) error {
 	}
 
 	verifier := provider.Verifier(oidcConfig)
-	_, err = verifier.Verify(ctx, rawAccessToken)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	idTokenVerifiers.Store(realmConfigURL, verifier)
+	return verifier.Verify(ctx, rawAccessToken)
 }
 
 // Authorize function used to validate user JWT token expiration status
@@ -0,0 +1,547 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"b0k3ts/configs"
+	"b0k3ts/internal/pkg/audit"
+	"b0k3ts/internal/pkg/auth"
+	"b0k3ts/internal/pkg/buckets"
+	"b0k3ts/internal/pkg/storage"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+// --- Constants / Types ---
+
+const (
+	defaultDirectory = "/opt/b0k3ts/backups"
+	defaultPrefix    = "b0k3ts-backups/"
+	defaultRetention = 7
+
+	defaultGCInterval     = 10 * time.Minute
+	defaultGCDiscardRatio = 0.5
+
+	fileSuffix    = ".bak"
+	fileTimestamp = "20060102T150405Z"
+
+	// restoredKey remembers the checksum of the last restored file so a
+	// restoreFrom left in config.yaml doesn't restore on every start.
+	restoredKey = storage.KeyBackupRestored
+
+	restoreMaxPendingWrites = 256
+)
+
+var (
+	errNotBadger      = errors.New("backups need the badger storage backend; back up postgres with its own tools")
+	errUploadNeedsKey = errors.New("backup.connection needs backup.encryptionKey: backups hold every stored secret")
+)
+
+// Backup is one backup file, on disk or in the backup connection.
+type Backup struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	Location  string    `json:"location"` // "local" or "s3"
+}
+
+// Manager takes backups and applies retention. Only one backup runs at a
+// time.
+type Manager struct {
+	db  storage.Store
+	cfg configs.BackupConfig
+	mu  sync.Mutex
+}
+
+func New(db storage.Store, cfg configs.BackupConfig) *Manager {
+	if cfg.Directory == "" {
+		cfg.Directory = defaultDirectory
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = defaultPrefix
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = defaultRetention
+	}
+	return &Manager{db: db, cfg: cfg}
+}
+
+// --- Public: Route registration ---
+
+// RegisterRoutes mounts the admin-only backup APIs.
+// Recommended mount point: /api/v1/backups
+func RegisterRoutes(rg *gin.RouterGroup, m *Manager) {
+	h := &handler{m: m}
+	rg.POST("/run", h.Run)
+	rg.GET("/list", h.List)
+}
+
+type handler struct {
+	m *Manager
+}
+
+// --- Backups ---
+
+// Start runs scheduled backups until ctx is done, returning after any
+// backup in progress. It returns at once when no interval is configured.
+func (m *Manager) Start(ctx context.Context) {
+	if m.cfg.Interval <= 0 {
+		return
+	}
+	if _, err := badgerDB(m.db); err != nil {
+		slog.Warn("scheduled backups disabled", "err", err)
+		return
+	}
+	slog.Info("scheduled backups enabled", "interval", m.cfg.Interval, "directory", m.cfg.Directory, "connection", m.cfg.Connection)
+	if m.cfg.EncryptionKey == (configs.SecretRef{}) {
+		slog.Warn("backups are not encrypted and hold every stored secret; set backup.encryptionKey")
+	}
+
+	t := time.NewTicker(m.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := m.Run(ctx); err != nil {
+				slog.Error("scheduled backup failed", "err", err)
+			}
+		}
+	}
+}
+
+// Run takes a full online backup, uploads it to the backup connection if
+// one is configured, and prunes old backups.
+func (m *Manager) Run(ctx context.Context) (Backup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, err := m.cfg.EncryptionKey.Resolve()
+	if err != nil {
+		return Backup{}, fmt.Errorf("backup.encryptionKey: %w", err)
+	}
+	if m.cfg.Connection != "" && key == "" {
+		return Backup{}, errUploadNeedsKey
+	}
+
+	if err := os.MkdirAll(m.cfg.Directory, 0o700); err != nil {
+		return Backup{}, err
+	}
+
+	now := time.Now().UTC()
+	name := "b0k3ts-" + now.Format(fileTimestamp) + fileSuffix
+	path := filepath.Join(m.cfg.Directory, name)
+
+	size, err := m.writeBackup(path, key)
+	if err != nil {
+		return Backup{}, err
+	}
+	b := Backup{Name: name, Size: size, CreatedAt: now, Location: "local"}
+	slog.Info("backup written", "file", path, "size", size)
+
+	if m.cfg.Connection != "" {
+		if err := m.upload(ctx, path, name); err != nil {
+			return b, fmt.Errorf("backup written to %s but upload failed: %w", path, err)
+		}
+		b.Location = "s3"
+	}
+
+	m.prune(ctx)
+	return b, nil
+}
+
+// List returns local and uploaded backups, newest first.
+func (m *Manager) List(ctx context.Context) ([]Backup, error) {
+	out, err := m.localBackups()
+	if err != nil {
+		return nil, err
+	}
+
+	if m.cfg.Connection != "" {
+		remote, err := m.remoteBackups(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, remote...)
+	}
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
+
+// writeBackup writes a full backup to path, encrypted when key is set.
+func (m *Manager) writeBackup(path, key string) (int64, error) {
+	db, err := badgerDB(m.db)
+	if err != nil {
+		return 0, err
+	}
+
+	partial := path + ".partial"
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := backupTo(db, f, key); err != nil {
+		_ = f.Close()
+		_ = os.Remove(partial)
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(partial)
+		return 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(partial)
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(partial)
+		return 0, err
+	}
+
+	return info.Size(), os.Rename(partial, path)
+}
+
+func backupTo(db *badger.DB, w io.Writer, key string) error {
+	if key == "" {
+		_, err := db.Backup(w, 0)
+		return err
+	}
+	sw, err := newSealWriter(w, key)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Backup(sw, 0); err != nil {
+		return err
+	}
+	return sw.Close()
+}
+
+// connect returns a client for the backup connection. Anyone who can use
+// the connection can read the backups, so it must be admin-only.
+func (m *Manager) connect() (*minio.Client, buckets.BucketConfig, error) {
+	cfg, err := buckets.LoadConnection(m.db, m.cfg.Connection)
+	if err != nil {
+		return nil, cfg, fmt.Errorf("backup connection %q: %w", m.cfg.Connection, err)
+	}
+	if cfg.PerUser() {
+		return nil, cfg, errors.New("backup connection can't use per-user web_identity credentials")
+	}
+	if err := m.checkAdminOnly(cfg); err != nil {
+		return nil, cfg, err
+	}
+	mio, err := buckets.Connect(cfg)
+	return mio, cfg, err
+}
+
+// checkAdminOnly refuses a backup connection that non-admin users are
+// authorized for.
+func (m *Manager) checkAdminOnly(cfg buckets.BucketConfig) error {
+	if len(cfg.AuthorizedUsers) > 0 {
+		return fmt.Errorf("backup connection %q must not have authorized_users: they could read every stored secret", cfg.BucketName)
+	}
+	oic, err := auth.LoadOIDCConfig(m.db)
+	if err != nil {
+		return err
+	}
+	for _, g := range cfg.AuthorizedGroups {
+		if g != oic.AdminGroup {
+			return fmt.Errorf("backup connection %q must not authorize group %q: it could read every stored secret", cfg.BucketName, g)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) upload(ctx context.Context, path, name string) error {
+	mio, cfg, err := m.connect()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	_, err = mio.PutObject(ctx, cfg.BucketName, m.cfg.Prefix+name, f, info.Size(), minio.PutObjectOptions{
+		ContentType: buckets.OctetStream,
+	})
+	return err
+}
+
+// prune keeps the newest Retention backups in each place. Failures are
+// logged; the next run tries again.
+func (m *Manager) prune(ctx context.Context) {
+	local, err := m.localBackups()
+	if err != nil {
+		slog.Error("failed to list local backups", "err", err)
+	}
+	for _, b := range expired(local, m.cfg.Retention) {
+		if err := os.Remove(filepath.Join(m.cfg.Directory, b.Name)); err != nil {
+			slog.Error("failed to remove old backup", "file", b.Name, "err", err)
+		}
+	}
+
+	if m.cfg.Connection == "" {
+		return
+	}
+	remote, err := m.remoteBackups(ctx)
+	if err != nil {
+		slog.Error("failed to list uploaded backups", "err", err)
+		return
+	}
+	mio, cfg, err := m.connect()
+	if err != nil {
+		slog.Error("failed to connect for backup retention", "err", err)
+		return
+	}
+	for _, b := range expired(remote, m.cfg.Retention) {
+		if err := mio.RemoveObject(ctx, cfg.BucketName, m.cfg.Prefix+b.Name, minio.RemoveObjectOptions{}); err != nil {
+			slog.Error("failed to remove old uploaded backup", "key", m.cfg.Prefix+b.Name, "err", err)
+		}
+	}
+}
+
+func (m *Manager) localBackups() ([]Backup, error) {
+	entries, err := os.ReadDir(m.cfg.Directory)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Backup{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Backup, 0, len(entries))
+	for _, e := range entries {
+		created, ok := parseName(e.Name())
+		if !ok || e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		out = append(out, Backup{Name: e.Name(), Size: info.Size(), CreatedAt: created, Location: "local"})
+	}
+	return out, nil
+}
+
+func (m *Manager) remoteBackups(ctx context.Context) ([]Backup, error) {
+	mio, cfg, err := m.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Backup, 0)
+	for obj := range mio.ListObjects(ctx, cfg.BucketName, minio.ListObjectsOptions{Prefix: m.cfg.Prefix}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		name := strings.TrimPrefix(obj.Key, m.cfg.Prefix)
+		created, ok := parseName(name)
+		if !ok {
+			continue
+		}
+		out = append(out, Backup{Name: name, Size: obj.Size, CreatedAt: created, Location: "s3"})
+	}
+	return out, nil
+}
+
+// expired returns all but the newest keep backups.
+func expired(all []Backup, keep int) []Backup {
+	if len(all) <= keep {
+		return nil
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].CreatedAt.After(all[j].CreatedAt) })
+	return all[keep:]
+}
+
+func parseName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, "b0k3ts-") || !strings.HasSuffix(name, fileSuffix) {
+		return time.Time{}, false
+	}
+	ts := strings.TrimSuffix(strings.TrimPrefix(name, "b0k3ts-"), fileSuffix)
+	t, err := time.Parse(fileTimestamp, ts)
+	return t, err == nil
+}
+
+// --- Restore ---
+
+// Restore replaces the database with the backup at path, decrypting it with
+// key if it is encrypted. It runs before anything else touches the database,
+// and only once per file: the file's checksum is recorded and a matching
+// restore is skipped on later starts.
+func Restore(store storage.Store, path, key string) error {
+	db, err := badgerDB(store)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	if prev, err := storage.Get(store, restoredKey); err == nil && string(prev) == sum {
+		slog.Info("backup already restored, skipping", "file", path)
+		return nil
+	}
+
+	// The whole file is decrypted once before anything is dropped, so a
+	// wrong key or a damaged backup leaves the database alone.
+	if err := checkBackup(f, key); err != nil {
+		return err
+	}
+	r, err := openBackup(f, key)
+	if err != nil {
+		return err
+	}
+
+	slog.Warn("restoring database from backup; existing data is dropped", "file", path)
+	if err := db.DropAll(); err != nil {
+		return err
+	}
+	if err := db.Load(r, restoreMaxPendingWrites); err != nil {
+		return err
+	}
+	if err := storage.Put(store, restoredKey, []byte(sum)); err != nil {
+		return err
+	}
+
+	slog.Info("database restored from backup", "file", path)
+	return nil
+}
+
+// checkBackup reads the backup through from the start and rewinds it.
+func checkBackup(f *os.File, key string) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r, err := openBackup(f, key)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	return err
+}
+
+// --- Value-log GC ---
+
+// StartValueLogGC periodically reclaims value-log space until ctx is done.
+// Other backends manage their own space.
+func StartValueLogGC(ctx context.Context, store storage.Store, cfg configs.ValueLogGCConfig) {
+	db, err := badgerDB(store)
+	if cfg.Disabled || err != nil {
+		return
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultGCInterval
+	}
+	ratio := cfg.DiscardRatio
+	if ratio <= 0 || ratio >= 1 {
+		ratio = defaultGCDiscardRatio
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			// Each successful run rewrites one file; keep going until
+			// there is nothing left worth rewriting.
+			runs := 0
+			for ctx.Err() == nil && db.RunValueLogGC(ratio) == nil {
+				runs++
+			}
+			if runs > 0 {
+				slog.Info("value log gc reclaimed space", "files", runs)
+			}
+		}
+	}
+}
+
+func badgerDB(store storage.Store) (*badger.DB, error) {
+	b, ok := store.(*storage.Badger)
+	if !ok {
+		return nil, errNotBadger
+	}
+	return b.DB(), nil
+}
+
+// --- Gin handlers ---
+
+func (h *handler) Run(c *gin.Context) {
+	userInfo, ok := auth.VerifiedAdminOrRespond(c)
+	if !ok {
+		return
+	}
+
+	b, err := h.m.Run(c.Request.Context())
+	if err != nil {
+		slog.Error("backup failed", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := audit.Record(h.m.db, userInfo.Email, "backup_run", b.Name, map[string]string{
+		"location": b.Location,
+		"size":     fmt.Sprint(b.Size),
+	}); err != nil {
+		slog.Error("failed to record backup audit event", "err", err)
+	}
+
+	c.JSON(http.StatusOK, b)
+}
+
+func (h *handler) List(c *gin.Context) {
+	if _, ok := auth.VerifiedAdminOrRespond(c); !ok {
+		return
+	}
+
+	items, err := h.m.List(c.Request.Context())
+	if err != nil {
+		slog.Error("failed to list backups", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
@@ -0,0 +1,224 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"b0k3ts/internal/pkg/seal"
+)
+
+// Encrypted backups are a header followed by AES-256-GCM sealed chunks. Each
+// chunk's nonce carries its index and a final-chunk flag, so reordered,
+// dropped or truncated chunks fail to open.
+//
+//	magic (8) | version (1) | iterations (4) | salt (16) | nonce prefix (7) | chunks
+const (
+	sealMagic       = "B0K3TSBK"
+	sealVersion     = 1
+	sealChunkSize   = 64 << 10
+	sealPrefixSize  = 7
+	sealHeaderSize  = len(sealMagic) + 1 + 4 + seal.SaltSize + sealPrefixSize
+	sealMaxChunkIdx = 1<<32 - 1
+)
+
+var errSealedBackupCorrupt = errors.New("wrong backup encryption key or corrupted backup")
+
+type sealedStream struct {
+	gcm    cipher.AEAD
+	prefix []byte
+	header []byte // additional data for every chunk
+	index  uint64
+}
+
+func (s *sealedStream) nonce(last bool) []byte {
+	nonce := make([]byte, 0, s.gcm.NonceSize())
+	nonce = append(nonce, s.prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, uint32(s.index))
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// sealWriter encrypts a backup as it is written. Close seals the final
+// chunk; without it the backup can't be opened.
+type sealWriter struct {
+	sealedStream
+	w   io.Writer
+	buf []byte
+}
+
+func newSealWriter(w io.Writer, passphrase string) (*sealWriter, error) {
+	header := make([]byte, 0, sealHeaderSize)
+	header = append(header, sealMagic...)
+	header = append(header, sealVersion)
+	header = binary.BigEndian.AppendUint32(header, seal.Iterations)
+
+	random := make([]byte, seal.SaltSize+sealPrefixSize)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	header = append(header, random...)
+	salt, prefix := random[:seal.SaltSize], random[seal.SaltSize:]
+
+	gcm, err := seal.NewGCM(passphrase, salt, seal.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &sealWriter{
+		sealedStream: sealedStream{gcm: gcm, prefix: prefix, header: header},
+		w:            w,
+		buf:          make([]byte, 0, sealChunkSize),
+	}, nil
+}
+
+// Write buffers p. A full chunk is only sealed once more data arrives, so
+// the last one can be flagged on Close.
+func (s *sealWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(s.buf) == sealChunkSize {
+			if err := s.flush(false); err != nil {
+				return n - len(p), err
+			}
+		}
+		k := min(sealChunkSize-len(s.buf), len(p))
+		s.buf = append(s.buf, p[:k]...)
+		p = p[k:]
+	}
+	return n, nil
+}
+
+func (s *sealWriter) Close() error {
+	return s.flush(true)
+}
+
+func (s *sealWriter) flush(last bool) error {
+	if s.index > sealMaxChunkIdx {
+		return errors.New("backup is too large to encrypt")
+	}
+	if _, err := s.w.Write(s.gcm.Seal(nil, s.nonce(last), s.buf, s.header)); err != nil {
+		return err
+	}
+	s.buf = s.buf[:0]
+	s.index++
+	return nil
+}
+
+// isSealed reports whether r starts with an encrypted backup header,
+// without consuming it.
+func isSealed(r *bufio.Reader) bool {
+	head, _ := r.Peek(len(sealMagic))
+	return string(head) == sealMagic
+}
+
+// sealReader decrypts a backup written by sealWriter. Each chunk is
+// authenticated before any of it is returned.
+type sealReader struct {
+	sealedStream
+	r     io.Reader
+	chunk []byte
+	plain []byte
+	done  bool
+}
+
+func newSealReader(r io.Reader, passphrase string) (*sealReader, error) {
+	header := make([]byte, sealHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errSealedBackupCorrupt
+	}
+	if string(header[:len(sealMagic)]) != sealMagic || header[len(sealMagic)] != sealVersion {
+		return nil, errors.New("unsupported encrypted backup format")
+	}
+	rest := header[len(sealMagic)+1:]
+	iterations := int(binary.BigEndian.Uint32(rest))
+	if iterations <= 0 || iterations > 10*seal.Iterations {
+		return nil, errors.New("unsupported encrypted backup key derivation")
+	}
+	salt := rest[4 : 4+seal.SaltSize]
+	prefix := rest[4+seal.SaltSize:]
+
+	gcm, err := seal.NewGCM(passphrase, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sealReader{
+		sealedStream: sealedStream{gcm: gcm, prefix: prefix, header: header},
+		r:            r,
+		chunk:        make([]byte, sealChunkSize+gcm.Overhead()),
+	}, nil
+}
+
+func (s *sealReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+// next opens the following chunk. A short chunk must be the last one; a
+// full one may be either, and the last one must be followed by EOF.
+func (s *sealReader) next() error {
+	n, err := io.ReadFull(s.r, s.chunk)
+	switch {
+	case err == io.EOF:
+		return errSealedBackupCorrupt // truncated before the last chunk
+	case err == io.ErrUnexpectedEOF:
+		return s.open(s.chunk[:n], true)
+	case err != nil:
+		return err
+	}
+
+	if s.open(s.chunk, false) == nil {
+		return nil
+	}
+	if err := s.open(s.chunk, true); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(s.r, make([]byte, 1)); err != io.EOF {
+		return errSealedBackupCorrupt
+	}
+	return nil
+}
+
+func (s *sealReader) open(chunk []byte, last bool) error {
+	if s.index > sealMaxChunkIdx {
+		return errSealedBackupCorrupt
+	}
+	plain, err := s.gcm.Open(nil, s.nonce(last), chunk, s.header)
+	if err != nil {
+		return errSealedBackupCorrupt
+	}
+	s.plain = plain
+	s.done = last
+	s.index++
+	return nil
+}
+
+// openBackup returns the backup's contents, decrypting them if needed.
+func openBackup(r io.Reader, passphrase string) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if !isSealed(br) {
+		return br, nil
+	}
+	if passphrase == "" {
+		return nil, errors.New("backup is encrypted; set backup.encryptionKey")
+	}
+	return newSealReader(br, passphrase)
+}
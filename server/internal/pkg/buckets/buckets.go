@@ -2,13 +2,12 @@ package buckets
 
 import (
 	"b0k3ts/configs"
+	"b0k3ts/internal/pkg/audit"
 	"b0k3ts/internal/pkg/auth"
-	badgerDB "b0k3ts/internal/pkg/badger"
+	"b0k3ts/internal/pkg/storage"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"log/slog"
 	"net/http"
 	"net/url"
@@ -18,16 +17,15 @@ import (
 	"strings"
 	"time"
 
-	"github.com/dgraph-io/badger/v4"
 	"github.com/gin-gonic/gin"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/cors"
-	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 	"github.com/samber/lo"
 )
 
 const OctetStream = "application/octet-stream"
-const BucketIdPrefix = "bucket-"
+const BucketIdPrefix = storage.NSConnections
 
 type PresignDownloadRequest struct {
 	Bucket         string `json:"bucket"`                    // bucket connection id
@@ -48,9 +46,112 @@ type BucketConfig struct {
 	SecretAccessKey  string   `json:"secret_access_key"`
 	Secure           bool     `json:"secure"`
 	BucketName       string   `json:"bucket_name"`
-	Location         string   `json:"location"`
+	Location         string   `json:"location"`         // region; detected with GetBucketLocation when empty
 	AuthorizedUsers  []string `json:"authorized_users"` // Email
 	AuthorizedGroups []string `json:"authorized_groups"`
+
+	Version      int64              `json:"version,omitempty"`       // bumped on every update; set by the server
+	Encryption   *EncryptionConfig  `json:"encryption,omitempty"`    // default encryption for new objects
+	Credentials  *CredentialsConfig `json:"credentials,omitempty"`   // nil = static access_key_id / secret_access_key
+	TLS          *TLSConfig         `json:"tls,omitempty"`           // custom CA, client cert, SNI; needs secure
+	BucketLookup string             `json:"bucket_lookup,omitempty"` // auto (default), path or dns
+	Managed      bool               `json:"managed,omitempty"`       // declared in config.yaml; read-only here
+	SecretRefs   *SecretRefs        `json:"secret_refs,omitempty"`   // managed: where the secrets are read from
+
+	// The caller's bearer token and the OIDC provider that must have signed
+	// it, set by withCaller for web_identity credentials. Never stored.
+	callerToken     string
+	oidcProviderURL string
+}
+
+// BucketConnection is a connection as shown in the connection list, with the
+// last known bucket policy state so public buckets stand out.
+type BucketConnection struct {
+	BucketConfig
+	Policy *BucketPolicyStatus `json:"policy,omitempty"` // nil until the policy has been checked
+}
+
+// redactedSecret replaces a stored secret in connection listings. Sending it
+// back to add_connection keeps the stored value.
+const redactedSecret = "(set)"
+
+// redacted returns a copy of the connection with its secrets replaced by
+// redactedSecret. The TLS and credentials settings are copied so the stored
+// config is left alone.
+func (cfg BucketConfig) redacted() BucketConfig {
+	cfg.SecretAccessKey = redact(cfg.SecretAccessKey)
+	if cfg.TLS != nil {
+		t := *cfg.TLS
+		t.ClientKey = redact(t.ClientKey)
+		cfg.TLS = &t
+	}
+	if cfg.Credentials != nil {
+		cr := *cfg.Credentials
+		cr.SessionToken = redact(cr.SessionToken)
+		cfg.Credentials = &cr
+	}
+	if cfg.SecretRefs != nil {
+		refs := *cfg.SecretRefs
+		refs.SecretAccessKey.Value = redact(refs.SecretAccessKey.Value)
+		refs.ClientKey.Value = redact(refs.ClientKey.Value)
+		cfg.SecretRefs = &refs
+	}
+	return cfg
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redactedSecret
+}
+
+// restoreRedactedSecrets puts the stored secrets back where an update sent
+// redactedSecret. Only a user authorized for the stored connection may keep
+// its secrets, and only for the same endpoint, so they can't be pointed at
+// another server.
+func (app *App) restoreRedactedSecrets(userInfo auth.User, cfg *BucketConfig) error {
+	if !hasRedactedSecrets(*cfg) {
+		return nil
+	}
+
+	old, err := connectionRepo(app.DB).Get(cfg.BucketName)
+	if storage.IsNotFound(err) {
+		return httpError{status: 400, msg: "send the secrets in full: there is no stored connection to keep them from"}
+	}
+	if err != nil {
+		return err
+	}
+	if !isAuthorizedForBucket(*app, userInfo, old) || old.Endpoint != cfg.Endpoint {
+		return httpError{status: 400, msg: "send the secrets in full: stored ones are only kept for the same endpoint by an authorized user"}
+	}
+	return keepSecrets(cfg, old)
+}
+
+func hasRedactedSecrets(cfg BucketConfig) bool {
+	return cfg.SecretAccessKey == redactedSecret ||
+		(cfg.TLS != nil && cfg.TLS.ClientKey == redactedSecret) ||
+		(cfg.Credentials != nil && cfg.Credentials.SessionToken == redactedSecret)
+}
+
+// keepSecrets replaces each redactedSecret in cfg with the value from old.
+func keepSecrets(cfg *BucketConfig, old BucketConfig) error {
+	if cfg.SecretAccessKey == redactedSecret {
+		cfg.SecretAccessKey = old.SecretAccessKey
+	}
+	if cfg.TLS != nil && cfg.TLS.ClientKey == redactedSecret {
+		if old.TLS == nil || old.TLS.ClientKey == "" {
+			return httpError{status: 400, msg: "tls.client_key has no stored value to keep"}
+		}
+		cfg.TLS.ClientKey = old.TLS.ClientKey
+	}
+	if cfg.Credentials != nil && cfg.Credentials.SessionToken == redactedSecret {
+		if old.Credentials == nil || old.Credentials.SessionToken == "" {
+			return httpError{status: 400, msg: "credentials.session_token has no stored value to keep"}
+		}
+		cfg.Credentials.SessionToken = old.Credentials.SessionToken
+	}
+	return nil
 }
 
 type BucketDeleteRequest struct {
@@ -70,24 +171,29 @@ type MultipartInitiateRequest struct {
 	Bucket      string `json:"bucket"`       // bucket connection id (same meaning as your other endpoints)
 	Key         string `json:"key"`          // object key/path in the bucket
 	ContentType string `json:"content_type"` // optional; defaults to application/octet-stream
+
+	Encryption *EncryptionOverride `json:"encryption,omitempty"` // optional; defaults to the connection setting
 }
 
 type MultipartInitiateResponse struct {
-	Bucket   string `json:"bucket"`
-	Key      string `json:"key"`
-	UploadID string `json:"upload_id"`
+	Bucket     string `json:"bucket"`
+	Key        string `json:"key"`
+	UploadID   string `json:"upload_id"`
+	Encryption string `json:"encryption"` // none, SSE-S3, SSE-KMS or SSE-C
 }
 
 type MultipartPresignPartRequest struct {
 	Bucket         string `json:"bucket"`
 	Key            string `json:"key"`
 	UploadID       string `json:"upload_id"`
-	PartNumber     int    `json:"part_number"`     // 1..10000
-	ExpiresSeconds int64  `json:"expires_seconds"` // optional; default 900
+	PartNumber     int    `json:"part_number"`                // 1..10000
+	ExpiresSeconds int64  `json:"expires_seconds"`            // optional; default 900
+	SSECustomerKey string `json:"sse_customer_key,omitempty"` // SSE-C uploads only
 }
 
 type MultipartPresignPartResponse struct {
-	URL string `json:"url"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"` // SSE-C: must be sent with the PUT
 }
 
 type MultipartCompletedPart struct {
@@ -100,6 +206,8 @@ type MultipartCompleteRequest struct {
 	Key      string                   `json:"key"`
 	UploadID string                   `json:"upload_id"`
 	Parts    []MultipartCompletedPart `json:"parts"`
+
+	SSECustomerKey string `json:"sse_customer_key,omitempty"` // SSE-C uploads only
 }
 
 type MultipartAbortRequest struct {
@@ -207,18 +315,20 @@ func (app *App) Move(c *gin.Context) {
 	// Determine mode: single-object move vs prefix move.
 	if isSingleObjectMove(req) {
 		if err := moveSingleObject(ctx, mio, bucketConfig.BucketName, req); err != nil {
-			respondMoveError(c, err)
+			respondError(c, err)
 			return
 		}
+		publishMoveEvent(c, req.Bucket, EventObjectCopied, req.FromKey, req.ToKey)
 		c.JSON(200, ObjectMoveResponse{Moved: 1})
 		return
 	}
 
 	moved, err := moveByPrefix(ctx, mio, bucketConfig.BucketName, req)
 	if err != nil {
-		respondMoveError(c, err)
+		respondError(c, err)
 		return
 	}
+	publishMoveEvent(c, req.Bucket, EventPrefixMoved, normalizePrefix(req.FromPrefix), normalizePrefix(req.ToPrefix))
 	c.JSON(200, ObjectMoveResponse{Moved: moved})
 }
 
@@ -392,7 +502,7 @@ type httpError struct {
 
 func (e httpError) Error() string { return e.msg }
 
-func respondMoveError(c *gin.Context, err error) {
+func respondError(c *gin.Context, err error) {
 	if he, ok := err.(httpError); ok {
 		if he.body != nil {
 			c.JSON(he.status, he.body)
@@ -405,9 +515,6 @@ func respondMoveError(c *gin.Context, err error) {
 }
 
 func (app *App) MultipartInitiate(c *gin.Context) {
-
-	ctx := context.Background()
-
 	var req MultipartInitiateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		slog.Error("multipart initiate failed. failed to bind json", "err", err)
@@ -424,12 +531,34 @@ func (app *App) MultipartInitiate(c *gin.Context) {
 		return
 	}
 
-	core, err := ConnectCore(*bucketConfig)
+	sse, err := uploadEncryption(*bucketConfig, req.Encryption)
 	if err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+		respondError(c, err)
+		return
+	}
+
+	uploadID, err := multipartInitiate(*bucketConfig, req.Key, req.ContentType, sse)
+	if err != nil {
+		respondError(c, err)
 		return
 	}
 
+	c.JSON(200, MultipartInitiateResponse{
+		Bucket:     req.Bucket,
+		Key:        req.Key,
+		UploadID:   uploadID,
+		Encryption: encryptionMode(sse),
+	})
+}
+
+func multipartInitiate(bucketConfig BucketConfig, key, contentType string, sse encrypt.ServerSide) (string, error) {
+	ctx := context.Background()
+
+	core, err := ConnectCore(bucketConfig)
+	if err != nil {
+		return "", err
+	}
+
 	slog.Info("setting cors for bucket", "bucket", bucketConfig.BucketName)
 
 	cfg := cors.Config{
@@ -445,28 +574,24 @@ func (app *App) MultipartInitiate(c *gin.Context) {
 	}
 
 	if err := core.SetBucketCors(ctx, bucketConfig.BucketName, &cfg); err != nil {
-		log.Fatal(err)
+		slog.Error("failed to set bucket cors", "bucket", bucketConfig.BucketName, "err", err)
+		return "", err
 	}
 
-	contentType := req.ContentType
 	if contentType == "" {
 		contentType = OctetStream
 	}
 
-	uploadID, err := core.NewMultipartUpload(ctx, bucketConfig.BucketName, req.Key, minio.PutObjectOptions{
-		ContentType: contentType,
+	uploadID, err := core.NewMultipartUpload(ctx, bucketConfig.BucketName, key, minio.PutObjectOptions{
+		ContentType:          contentType,
+		ServerSideEncryption: sse,
 	})
 	if err != nil {
 		slog.Error("failed to initiate multipart upload", "err", err)
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
+		return "", err
 	}
 
-	c.JSON(200, MultipartInitiateResponse{
-		Bucket:   req.Bucket,
-		Key:      req.Key,
-		UploadID: uploadID,
-	})
+	return uploadID, nil
 }
 
 func (app *App) MultipartPresignPart(c *gin.Context) {
@@ -490,20 +615,33 @@ func (app *App) MultipartPresignPart(c *gin.Context) {
 		return
 	}
 
-	mio, err := Connect(*bucketConfig)
+	res, err := multipartPresignPart(*bucketConfig, req)
 	if err != nil {
-		slog.Error(err.Error())
-		c.JSON(400, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
+	c.JSON(200, res)
+}
+
+func multipartPresignPart(bucketConfig BucketConfig, req MultipartPresignPartRequest) (MultipartPresignPartResponse, error) {
+	sse, err := customerKeyEncryption(bucketConfig, req.SSECustomerKey)
+	if err != nil {
+		return MultipartPresignPartResponse{}, err
+	}
+
+	mio, err := Connect(bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		return MultipartPresignPartResponse{}, err
+	}
+
 	expires := req.ExpiresSeconds
 	if expires <= 0 {
 		expires = 900
 	}
 	if expires > 7*24*3600 {
-		c.JSON(400, gin.H{"error": "expires_seconds too large"})
-		return
+		return MultipartPresignPartResponse{}, httpError{status: 400, msg: "expires_seconds too large"}
 	}
 
 	ctx := context.Background()
@@ -512,14 +650,24 @@ func (app *App) MultipartPresignPart(c *gin.Context) {
 	q.Set("partNumber", strconv.Itoa(req.PartNumber))
 	q.Set("uploadId", req.UploadID)
 
-	u, err := mio.Presign(ctx, http.MethodPut, bucketConfig.BucketName, req.Key, time.Duration(expires)*time.Second, q)
+	// SSE-C parts must carry the key headers; they're signed into the URL,
+	// so the client has to send exactly these.
+	headers := sseHeaders(sse)
+
+	u, err := mio.PresignHeader(ctx, http.MethodPut, bucketConfig.BucketName, req.Key, time.Duration(expires)*time.Second, q, headers)
 	if err != nil {
 		slog.Error("failed to presign part url", "err", err)
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
+		return MultipartPresignPartResponse{}, err
 	}
 
-	c.JSON(200, MultipartPresignPartResponse{URL: u.String()})
+	res := MultipartPresignPartResponse{URL: u.String()}
+	if len(headers) > 0 {
+		res.Headers = make(map[string]string, len(headers))
+		for k := range headers {
+			res.Headers[k] = headers.Get(k)
+		}
+	}
+	return res, nil
 }
 
 func (app *App) MultipartComplete(c *gin.Context) {
@@ -543,17 +691,32 @@ func (app *App) MultipartComplete(c *gin.Context) {
 		return
 	}
 
-	core, err := ConnectCore(*bucketConfig)
-	if err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+	if err := multipartComplete(*bucketConfig, req); err != nil {
+		respondError(c, err)
 		return
 	}
 
+	userInfo, _ := auth.TokenToUserData(c.GetHeader("Authorization"))
+	publishObjectEvent(req.Bucket, EventObjectCreated, req.Key, userInfo.Email, 0)
+
+	c.JSON(200, gin.H{"message": "Multipart upload completed"})
+}
+
+func multipartComplete(bucketConfig BucketConfig, req MultipartCompleteRequest) error {
+	sse, err := customerKeyEncryption(bucketConfig, req.SSECustomerKey)
+	if err != nil {
+		return err
+	}
+
+	core, err := ConnectCore(bucketConfig)
+	if err != nil {
+		return err
+	}
+
 	parts := make([]minio.CompletePart, 0, len(req.Parts))
 	for _, p := range req.Parts {
 		if p.PartNumber < 1 || p.PartNumber > 10000 || p.ETag == "" {
-			c.JSON(400, gin.H{"error": "each part must have valid part_number and non-empty etag"})
-			return
+			return httpError{status: 400, msg: "each part must have valid part_number and non-empty etag"}
 		}
 		parts = append(parts, minio.CompletePart{
 			PartNumber: p.PartNumber,
@@ -565,14 +728,15 @@ func (app *App) MultipartComplete(c *gin.Context) {
 
 	ctx := context.Background()
 
-	_, err = core.CompleteMultipartUpload(ctx, bucketConfig.BucketName, req.Key, req.UploadID, parts, minio.PutObjectOptions{})
+	_, err = core.CompleteMultipartUpload(ctx, bucketConfig.BucketName, req.Key, req.UploadID, parts, minio.PutObjectOptions{
+		ServerSideEncryption: sse,
+	})
 	if err != nil {
 		slog.Error("failed to complete multipart upload", "err", err)
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
+		return err
 	}
 
-	c.JSON(200, gin.H{"message": "Multipart upload completed"})
+	return nil
 }
 
 func (app *App) MultipartAbort(c *gin.Context) {
@@ -592,22 +756,28 @@ func (app *App) MultipartAbort(c *gin.Context) {
 		return
 	}
 
-	core, err := ConnectCore(*bucketConfig)
-	if err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+	if err := multipartAbort(*bucketConfig, req.Key, req.UploadID); err != nil {
+		respondError(c, err)
 		return
 	}
 
-	ctx := context.Background()
+	c.JSON(200, gin.H{"message": "Multipart upload aborted"})
+}
 
-	err = core.AbortMultipartUpload(ctx, bucketConfig.BucketName, req.Key, req.UploadID)
+func multipartAbort(bucketConfig BucketConfig, key, uploadID string) error {
+	core, err := ConnectCore(bucketConfig)
 	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if err := core.AbortMultipartUpload(ctx, bucketConfig.BucketName, key, uploadID); err != nil {
 		slog.Error("failed to abort multipart upload", "err", err)
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
+		return err
 	}
 
-	c.JSON(200, gin.H{"message": "Multipart upload aborted"})
+	return nil
 }
 
 // ... existing code ...
@@ -618,12 +788,14 @@ type ObjectRequest struct {
 }
 
 type ObjectDownloadRequest struct {
-	Bucket   string `json:"bucket"`
-	Filename string `json:"filename"`
+	Bucket         string `json:"bucket"`
+	Filename       string `json:"filename"`
+	SSECustomerKey string `json:"sse_customer_key,omitempty"` // SSE-C objects only
 }
 type ObjectDeleteRequest struct {
-	Bucket   string `json:"bucket"`
-	Filename string `json:"filename"`
+	Bucket           string `json:"bucket"`
+	Filename         string `json:"filename"`
+	GovernanceBypass bool   `json:"governance_bypass,omitempty"` // admin only; bypasses GOVERNANCE retention
 }
 
 type ObjectDownloadResponse struct {
@@ -631,14 +803,16 @@ type ObjectDownloadResponse struct {
 }
 
 type Buckets struct {
-	Client   *minio.Client
-	Config   BucketConfig
-	BadgerDB *badger.DB
+	Client *minio.Client
+	Config BucketConfig
+	DB     storage.Store
 }
 
 type App struct {
-	DB         *badger.DB
+	DB         storage.Store
 	OIDCConfig configs.OIDC
+
+	jobs *jobTracker // background extraction jobs
 }
 
 type Object struct {
@@ -647,52 +821,8 @@ type Object struct {
 	ContentType string `json:"content_type"`
 }
 
-func NewConfig(db *badger.DB, oidcConfig configs.OIDC) *App {
-	return &App{DB: db, OIDCConfig: oidcConfig}
-}
-
-func scanByPrefix(db *badger.DB, prefixStr string) [][]byte {
-
-	var results [][]byte
-
-	err := db.View(func(txn *badger.Txn) error {
-		// 1. Setup Iterator Options
-		opts := badger.DefaultIteratorOptions
-		opts.Prefix = []byte(prefixStr) // Optimization: skip irrelevant tables
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		// 2. Iterate
-		// Seek: Jumps to the first key that matches or is greater than the prefix
-		// ValidForPrefix: returns false when we pass the end of the prefix
-		prefixBytes := []byte(prefixStr)
-		for it.Seek(prefixBytes); it.ValidForPrefix(prefixBytes); it.Next() {
-			item := it.Item()
-
-			// 3. Retrieve Value
-			err := item.Value(func(v []byte) error {
-				valCopy, err := item.ValueCopy(nil)
-				if err != nil {
-					return err
-				}
-
-				results = append(results, valCopy)
-
-				return nil
-			})
-			if err != nil {
-				return err
-			}
-		}
-		return nil
-	})
-
-	if err != nil {
-		slog.Error(err.Error())
-	}
-
-	return results
+func NewConfig(db storage.Store, oidcConfig configs.OIDC) *App {
+	return &App{DB: db, OIDCConfig: oidcConfig, jobs: newJobTracker()}
 }
 
 func (app *App) DeleteConnection(c *gin.Context) {
@@ -717,7 +847,12 @@ func (app *App) DeleteConnection(c *gin.Context) {
 		return
 	}
 
-	if err := badgerDB.DeleteKV(app.DB, BucketIdPrefix+req.BucketId); err != nil {
+	if bucketConfig.Managed {
+		respondError(c, errManagedConnection)
+		return
+	}
+
+	if err := deleteConnection(app.DB, req.BucketId); err != nil {
 		slog.Error(err.Error())
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
@@ -739,29 +874,54 @@ func tokenUserOrRespond(c *gin.Context) (auth.User, bool) {
 func bindDeleteConnectionRequest(c *gin.Context) (BucketDeleteRequest, bool) {
 	var req BucketDeleteRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		slog.Error("delete connection failed. failed to bind json: ", err)
+		slog.Error("delete connection failed. failed to bind json", "err", err)
 		c.JSON(400, gin.H{"error": err.Error()})
 		return BucketDeleteRequest{}, false
 	}
 	return req, true
 }
 
-func getBucketConfigOrRespond(c *gin.Context, db *badger.DB, bucketID string) (BucketConfig, bool) {
-	res, err := badgerDB.PullKV(db, BucketIdPrefix+bucketID)
+func getBucketConfigOrRespond(c *gin.Context, db storage.Store, bucketID string) (BucketConfig, bool) {
+	bucketConfig, err := connectionRepo(db).Get(bucketID)
 	if err != nil {
-		slog.Error(err.Error())
+		slog.Error(err.Error(), "bucket", bucketID)
 		c.JSON(400, gin.H{"error": err.Error()})
 		return BucketConfig{}, false
 	}
 
-	var bucketConfig BucketConfig
-	if err := json.Unmarshal(res, &bucketConfig); err != nil {
-		slog.Error(err.Error())
-		c.JSON(400, gin.H{"error": err.Error()})
-		return BucketConfig{}, false
+	return bucketConfig, true
+}
+
+// connectionRepo stores connections keyed by bucket name.
+func connectionRepo(db storage.Store) storage.Repository[BucketConfig] {
+	return storage.NewRepository[BucketConfig](db, BucketIdPrefix)
+}
+
+// policyStatusRepo stores the last policy check per connection.
+func policyStatusRepo(db storage.Store) storage.Repository[BucketPolicyStatus] {
+	return storage.NewRepository[BucketPolicyStatus](db, PolicyStatusPrefix)
+}
+
+// LoadConnection returns a stored connection for server-side jobs that have
+// no request (and so no caller) behind them.
+func LoadConnection(db storage.Store, bucketID string) (BucketConfig, error) {
+	return connectionRepo(db).Get(bucketID)
+}
+
+// deleteConnection removes a connection together with its policy status.
+func deleteConnection(db storage.Store, bucketID string) error {
+	err := db.Update(func(tx storage.Tx) error {
+		if err := connectionRepo(db).Tx(tx).Delete(bucketID); err != nil {
+			return err
+		}
+		return policyStatusRepo(db).Tx(tx).Delete(bucketID)
+	})
+	if err != nil {
+		return err
 	}
 
-	return bucketConfig, true
+	s3Clients.invalidate(bucketID)
+	return nil
 }
 
 func isAuthorizedForBucket(app App, userInfo auth.User, bucketConfig BucketConfig) bool {
@@ -802,31 +962,25 @@ func (app *App) ListConnection(c *gin.Context) {
 		return
 	}
 
-	c.JSON(200, filterAuthorizedBucketConfigs(*app, userInfo, configs))
-}
-
-func listBucketConfigsOrRespond(c *gin.Context, db *badger.DB) ([]BucketConfig, bool) {
-	raw := scanByPrefix(db, "bucket-")
-
-	cfgs := make([]BucketConfig, 0, len(raw))
-	for _, val := range raw {
-		cfg, err := unmarshalBucketConfig(val)
-		if err != nil {
-			slog.Error(err.Error())
-			c.JSON(400, gin.H{"error": err.Error()})
-			return nil, false
-		}
-		cfgs = append(cfgs, cfg)
+	authorized := filterAuthorizedBucketConfigs(*app, userInfo, configs)
+	for i := range authorized {
+		app.withCaller(c, &authorized[i])
 	}
-	return cfgs, true
+	conns := app.withPolicyStatus(authorized, c.Query("refresh_policy") == "true")
+	for i := range conns {
+		conns[i].BucketConfig = conns[i].BucketConfig.redacted()
+	}
+	c.JSON(200, conns)
 }
 
-func unmarshalBucketConfig(b []byte) (BucketConfig, error) {
-	var cfg BucketConfig
-	if err := json.Unmarshal(b, &cfg); err != nil {
-		return BucketConfig{}, err
+func listBucketConfigsOrRespond(c *gin.Context, db storage.Store) ([]BucketConfig, bool) {
+	cfgs, err := connectionRepo(db).List()
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return nil, false
 	}
-	return cfg, nil
+	return cfgs, true
 }
 
 func filterAuthorizedBucketConfigs(app App, userInfo auth.User, cfgs []BucketConfig) []BucketConfig {
@@ -855,44 +1009,102 @@ func (app *App) AddConnection(c *gin.Context) {
 	//
 	var bucketConfig BucketConfig
 	if err := c.ShouldBindJSON(&bucketConfig); err != nil {
-		slog.Error("add connection failed. failed to bind json: ", err)
+		slog.Error("add connection failed. failed to bind json", "err", err)
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Marshaling Bucket Config
-	//
-	res, err := json.Marshal(bucketConfig)
-	if err != nil {
-		slog.Error(err.Error())
+	if err := app.restoreRedactedSecrets(userInfo, &bucketConfig); err != nil {
+		respondError(c, err)
+		return
+	}
+	if err := validateBucketConfig(bucketConfig); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	if err := checkCredentialsSource(bucketConfig, userInfo.Administrator && isVerifiedAdmin(c)); err != nil {
+		respondError(c, err)
+		return
+	}
+	bucketConfig.Managed = false
 
-	// Creating Bucket Instance Connection for User
+	// Creating (or replacing) Bucket Instance Connection for User.
 	//
-	err = badgerDB.PutKV(app.DB, BucketIdPrefix+bucketConfig.BucketName, res)
+	prev, err := saveBucketConfig(app.DB, &bucketConfig)
 	if err != nil {
 		slog.Error(err.Error())
-		c.JSON(400, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
+	app.auditSkipVerify(userInfo.Email, prev, bucketConfig)
+
 	c.JSON(200, gin.H{"message": "Connection Added"})
 }
 
-func Connect(config BucketConfig) (*minio.Client, error) {
+// validateBucketConfig checks the optional settings of a connection.
+func validateBucketConfig(cfg BucketConfig) error {
+	if err := validateEncryptionConfig(cfg.Encryption); err != nil {
+		return err
+	}
+	if err := validateCredentialsConfig(cfg); err != nil {
+		return err
+	}
+	if err := validateTLSConfig(cfg); err != nil {
+		return err
+	}
+	return validateBucketLookup(cfg.BucketLookup)
+}
 
-	endpoint := config.Endpoint
-	accessKeyID := config.AccessKeyId
-	secretAccessKey := config.SecretAccessKey
-	useSSL := config.Secure
+// saveBucketConfig creates or replaces a connection and returns the one it
+// replaced, if any. The version bump lets pooled clients notice the change.
+// Managed connections can only be replaced by config.yaml.
+func saveBucketConfig(db storage.Store, cfg *BucketConfig) (*BucketConfig, error) {
+	var prev *BucketConfig
+	err := db.Update(func(tx storage.Tx) error {
+		repo := connectionRepo(db).Tx(tx)
+
+		cfg.Version = 1
+		old, err := repo.Get(cfg.BucketName)
+		switch {
+		case err == nil:
+			if old.Managed && !cfg.Managed {
+				return errManagedConnection
+			}
+			cfg.Version = old.Version + 1
+			prev = &old
+		case !storage.IsNotFound(err):
+			return err
+		}
 
-	// Initialize minio client object.
-	minioClient, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
-		Secure: useSSL,
+		return repo.Put(cfg.BucketName, *cfg)
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	s3Clients.invalidate(cfg.BucketName)
+	return prev, nil
+}
+
+// auditSkipVerify records turning TLS verification off (or back on).
+func (app *App) auditSkipVerify(actor string, prev *BucketConfig, cfg BucketConfig) {
+	wasSkipVerify := prev != nil && prev.TLS != nil && prev.TLS.InsecureSkipVerify
+	skipVerify := cfg.TLS != nil && cfg.TLS.InsecureSkipVerify
+	if !skipVerify && !wasSkipVerify {
+		return
+	}
+	if err := audit.Record(app.DB, actor, "connection_tls_skip_verify", cfg.BucketName, map[string]string{
+		"enabled":  fmt.Sprint(skipVerify),
+		"endpoint": cfg.Endpoint,
+	}); err != nil {
+		slog.Error("failed to record tls skip-verify audit event", "err", err)
+	}
+}
+
+// Connect returns the pooled client for a connection; see clientRegistry.
+func Connect(config BucketConfig) (*minio.Client, error) {
+	minioClient, err := s3Clients.client(config)
 	if err != nil {
 		slog.Error(err.Error())
 		return nil, err
@@ -902,20 +1114,11 @@ func Connect(config BucketConfig) (*minio.Client, error) {
 }
 
 func ConnectCore(config BucketConfig) (*minio.Core, error) {
-	endpoint := config.Endpoint
-	accessKeyID := config.AccessKeyId
-	secretAccessKey := config.SecretAccessKey
-	useSSL := config.Secure
-
-	core, err := minio.NewCore(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
-		Secure: useSSL,
-	})
+	minioClient, err := Connect(config)
 	if err != nil {
-		slog.Error(err.Error())
 		return nil, err
 	}
-	return core, nil
+	return &minio.Core{Client: minioClient}, nil
 }
 
 func (app *App) Upload(c *gin.Context) {
@@ -949,13 +1152,24 @@ func (app *App) DownloadNative(c *gin.Context) {
 		return
 	}
 
+	sse, err := customerKeyEncryption(*bucketConfig, c.GetHeader(SSECustomerKeyHeader))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	streamObject(c, mio, bucketConfig.BucketName, key, c.Query("disposition"), minio.GetObjectOptions{ServerSideEncryption: sse})
+}
+
+// streamObject writes an object to the response without buffering it in memory.
+func streamObject(c *gin.Context, mio *minio.Client, bucketName, key, disposition string, opts minio.GetObjectOptions) bool {
 	ctx := context.Background()
 
-	obj, err := mio.GetObject(ctx, bucketConfig.BucketName, key, minio.GetObjectOptions{})
+	obj, err := mio.GetObject(ctx, bucketName, key, opts)
 	if err != nil {
 		slog.Error(err.Error())
 		c.JSON(400, gin.H{"error": err.Error()})
-		return
+		return false
 	}
 	defer obj.Close()
 
@@ -963,7 +1177,7 @@ func (app *App) DownloadNative(c *gin.Context) {
 	if err != nil {
 		slog.Error(err.Error())
 		c.JSON(404, gin.H{"error": "object not found"})
-		return
+		return false
 	}
 
 	// Pick a friendly filename for the browser.
@@ -972,7 +1186,7 @@ func (app *App) DownloadNative(c *gin.Context) {
 		filename = "download"
 	}
 
-	disposition := strings.ToLower(strings.TrimSpace(c.Query("disposition")))
+	disposition = strings.ToLower(strings.TrimSpace(disposition))
 	if disposition != "inline" {
 		disposition = "attachment"
 	}
@@ -990,16 +1204,17 @@ func (app *App) DownloadNative(c *gin.Context) {
 	c.Status(http.StatusOK)
 	if _, err := io.Copy(c.Writer, obj); err != nil {
 		// At this point headers/body may already be partially written; just log.
-		slog.Error("stream download failed", "err", err, "bucket", bucketID, "key", key)
-		return
+		slog.Error("stream download failed", "err", err, "bucket", bucketName, "key", key)
+		return false
 	}
+	return true
 }
 
 func (app *App) Download(c *gin.Context) {
 
 	var req ObjectDownloadRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		slog.Error("download failed. failed to bind json: ", err)
+		slog.Error("download failed. failed to bind json", "err", err)
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
@@ -1009,6 +1224,12 @@ func (app *App) Download(c *gin.Context) {
 		return
 	}
 
+	sse, err := customerKeyEncryption(*bucketConfig, req.SSECustomerKey)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
 	ctx := context.Background()
 
 	mio, err := Connect(*bucketConfig)
@@ -1018,7 +1239,7 @@ func (app *App) Download(c *gin.Context) {
 		return
 	}
 
-	object, err := mio.GetObject(ctx, bucketConfig.BucketName, req.Filename, minio.GetObjectOptions{})
+	object, err := mio.GetObject(ctx, bucketConfig.BucketName, req.Filename, minio.GetObjectOptions{ServerSideEncryption: sse})
 	if err != nil {
 		slog.Error(err.Error())
 		c.JSON(400, gin.H{"error": err.Error()})
@@ -1041,7 +1262,7 @@ func (app *App) Download(c *gin.Context) {
 		return
 	}
 
-	slog.Info("Successfully downloaded %s", req.Filename)
+	slog.Info("Successfully downloaded", "filename", req.Filename)
 
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", req.Filename))
 	c.Header("Content-Type", OctetStream)
@@ -1056,11 +1277,18 @@ func (app *App) Delete(c *gin.Context) {
 
 	var req ObjectDeleteRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		slog.Error("delete failed. failed to bind json: ", err)
+		slog.Error("delete failed. failed to bind json", "err", err)
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
+	if req.GovernanceBypass {
+		if !isVerifiedAdmin(c) {
+			c.JSON(403, gin.H{"error": "governance_bypass requires an administrator"})
+			return
+		}
+	}
+
 	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
 	if bucketConfig == nil {
 		return
@@ -1075,14 +1303,25 @@ func (app *App) Delete(c *gin.Context) {
 		return
 	}
 
-	err = mio.RemoveObject(ctx, bucketConfig.BucketName, req.Filename, minio.RemoveObjectOptions{})
+	if err := checkDeleteAllowed(ctx, mio, bucketConfig.BucketName, req.Filename, req.GovernanceBypass); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	err = mio.RemoveObject(ctx, bucketConfig.BucketName, req.Filename, minio.RemoveObjectOptions{
+		GovernanceBypass: req.GovernanceBypass,
+	})
 	if err != nil {
 		slog.Error(err.Error())
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	slog.Info("Successfully deleted %s", req.Filename)
+	slog.Info("Successfully deleted", "filename", req.Filename)
+
+	userInfo, _ := auth.TokenToUserData(c.GetHeader("Authorization"))
+	publishObjectEvent(req.Bucket, EventObjectRemoved, req.Filename, userInfo.Email, 0)
+
 	c.JSON(200, gin.H{"message": "Object deleted successfully"})
 	return
 }
@@ -1136,7 +1375,7 @@ func (app *App) ListObjects(c *gin.Context) {
 			return
 		}
 
-		objects = append(objects, Object{object.Key, object.Size, object.ContentType})
+		objects = append(objects, Object{Key: object.Key, Size: object.Size, ContentType: object.ContentType})
 	}
 
 	slog.Info("Successfully listed objects")
@@ -1165,6 +1404,7 @@ func authorizeAndExtract(app App, c *gin.Context, bucketName string) *BucketConf
 		return nil
 	}
 
+	app.withCaller(c, &bucketConfig)
 	return &bucketConfig
 }
 
@@ -1175,7 +1415,7 @@ func bucketNameOrRespond(c *gin.Context, bucketName string) (string, bool) {
 
 	var req ObjectRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		slog.Error("authorize failed. failed to bind json: ", err)
+		slog.Error("authorize failed. failed to bind json", "err", err)
 		c.JSON(400, gin.H{"error": err.Error()})
 		return "", false
 	}
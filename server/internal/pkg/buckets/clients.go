@@ -0,0 +1,258 @@
+package buckets
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// HTTP transport tuning for S3 clients. One transport is shared per
+// connection so TCP/TLS sessions are reused across requests.
+const (
+	clientDialTimeout           = 10 * time.Second
+	clientKeepAlive             = 30 * time.Second
+	clientTLSHandshakeTimeout   = 10 * time.Second
+	clientResponseHeaderTimeout = 60 * time.Second
+	clientExpectContinueTimeout = time.Second
+	clientIdleConnTimeout       = 90 * time.Second
+	clientMaxIdleConns          = 256
+	clientMaxIdleConnsPerHost   = 64
+
+	regionDetectTimeout = 10 * time.Second
+)
+
+// clientRegistry pools S3 clients per connection. Entries are keyed by
+// connection id and remember the config version and fingerprint they were
+// built from, so an edited connection gets a fresh client even if a stale
+// entry was not invalidated.
+type clientRegistry struct {
+	mu      sync.Mutex
+	entries map[string]*clientEntry
+}
+
+type clientEntry struct {
+	version     int64
+	fingerprint string
+	transport   *http.Transport
+	client      *minio.Client // nil for per-user (web_identity) connections
+
+	// region is the configured location, or the detected one if it was empty.
+	region     string
+	regionOnce sync.Once
+}
+
+var s3Clients = &clientRegistry{entries: map[string]*clientEntry{}}
+
+// client returns a pooled client for cfg. web_identity connections share the
+// transport but get a client per call, because their credentials belong to
+// the caller (and are cached separately).
+func (r *clientRegistry) client(cfg BucketConfig) (*minio.Client, error) {
+	cfg, err := cfg.withSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := r.entry(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if entry.client != nil {
+		return entry.client, nil
+	}
+
+	creds, err := connectionCredentials(cfg, entry.transport)
+	if err != nil {
+		return nil, err
+	}
+
+	// Per-call clients can't keep minio-go's own location cache, so the
+	// region is detected once with the first caller's credentials.
+	if cfg.Location == "" {
+		entry.regionOnce.Do(func() {
+			if mio, err := newS3Client(cfg, creds, entry.transport, ""); err == nil {
+				entry.region = detectRegion(mio, cfg.BucketName)
+			}
+		})
+	}
+	return newS3Client(cfg, creds, entry.transport, entry.region)
+}
+
+func (r *clientRegistry) entry(cfg BucketConfig) (*clientEntry, error) {
+	id := cfg.BucketName
+	fingerprint := credentialsFingerprint(cfg)
+
+	r.mu.Lock()
+	if e, ok := r.entries[id]; ok && e.version == cfg.Version && e.fingerprint == fingerprint {
+		r.mu.Unlock()
+		return e, nil
+	}
+	r.mu.Unlock()
+
+	// Built without the lock: region detection talks to the backend.
+	e, err := newClientEntry(cfg, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cur, ok := r.entries[id]; ok && cur.version == cfg.Version && cur.fingerprint == fingerprint {
+		// Another request built the same entry first. Keep theirs; the
+		// credentials cache is shared, so only drop our connections.
+		e.transport.CloseIdleConnections()
+		return cur, nil
+	}
+	if old, ok := r.entries[id]; ok {
+		old.close()
+	}
+	r.entries[id] = e
+	return e, nil
+}
+
+func newClientEntry(cfg BucketConfig, fingerprint string) (*clientEntry, error) {
+	transport, err := newClientTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &clientEntry{version: cfg.Version, fingerprint: fingerprint, transport: transport, region: cfg.Location}
+	if cfg.PerUser() {
+		return e, nil
+	}
+
+	creds, err := connectionCredentials(cfg, transport)
+	if err != nil {
+		return nil, err
+	}
+	e.client, err = newS3Client(cfg, creds, transport, e.region)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Location == "" {
+		if e.region = detectRegion(e.client, cfg.BucketName); e.region != "" {
+			e.client, err = newS3Client(cfg, creds, transport, e.region)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return e, nil
+}
+
+func newS3Client(cfg BucketConfig, creds *credentials.Credentials, tr http.RoundTripper, region string) (*minio.Client, error) {
+	return minio.New(cfg.Endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       cfg.Secure,
+		Transport:    tr,
+		Region:       region,
+		BucketLookup: bucketLookupType(cfg.BucketLookup),
+	})
+}
+
+// detectRegion asks the backend for the bucket's region when the connection
+// has no location. Failures are logged and leave the region to minio-go.
+func detectRegion(mio *minio.Client, bucket string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), regionDetectTimeout)
+	defer cancel()
+
+	region, err := mio.GetBucketLocation(ctx, bucket)
+	if err != nil {
+		slog.Warn("bucket region detection failed", "bucket", bucket, "err", err)
+		return ""
+	}
+	slog.Info("detected bucket region", "bucket", bucket, "region", region)
+	return region
+}
+
+// Bucket addressing styles. auto lets minio-go pick: virtual-host for AWS
+// and known DNS-style endpoints, path-style otherwise.
+const (
+	BucketLookupAuto = "auto"
+	BucketLookupPath = "path"
+	BucketLookupDNS  = "dns"
+)
+
+func validateBucketLookup(mode string) error {
+	switch mode {
+	case "", BucketLookupAuto, BucketLookupPath, BucketLookupDNS:
+		return nil
+	}
+	return errors.New("bucket_lookup must be auto, path or dns")
+}
+
+func bucketLookupType(mode string) minio.BucketLookupType {
+	switch mode {
+	case BucketLookupPath:
+		return minio.BucketLookupPath
+	case BucketLookupDNS:
+		return minio.BucketLookupDNS
+	}
+	return minio.BucketLookupAuto
+}
+
+// invalidate drops the pooled client for a connection after it is updated or
+// deleted. In-flight requests keep the old client until they finish.
+func (r *clientRegistry) invalidate(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.entries[id]; ok {
+		e.close()
+		delete(r.entries, id)
+	}
+}
+
+// ResetClients drops every pooled client, e.g. after connections were
+// replaced wholesale by a state import.
+func ResetClients() {
+	s3Clients.mu.Lock()
+	defer s3Clients.mu.Unlock()
+
+	for id, e := range s3Clients.entries {
+		e.close()
+		delete(s3Clients.entries, id)
+	}
+}
+
+// close releases idle connections and the credentials built for this
+// version of the connection.
+func (e *clientEntry) close() {
+	e.transport.CloseIdleConnections()
+	credentialsCache.Delete(e.fingerprint)
+}
+
+func newClientTransport(cfg BucketConfig) (*http.Transport, error) {
+	tr, err := minio.DefaultTransport(cfg.Secure)
+	if err != nil {
+		return nil, err
+	}
+
+	tr.DialContext = (&net.Dialer{
+		Timeout:   clientDialTimeout,
+		KeepAlive: clientKeepAlive,
+	}).DialContext
+	tr.TLSHandshakeTimeout = clientTLSHandshakeTimeout
+	tr.ResponseHeaderTimeout = clientResponseHeaderTimeout
+	tr.ExpectContinueTimeout = clientExpectContinueTimeout
+	tr.IdleConnTimeout = clientIdleConnTimeout
+	tr.MaxIdleConns = clientMaxIdleConns
+	tr.MaxIdleConnsPerHost = clientMaxIdleConnsPerHost
+
+	if cfg.Secure {
+		tr.TLSClientConfig, err = applyTLSConfig(tr.TLSClientConfig, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tr, nil
+}
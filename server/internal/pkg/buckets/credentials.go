@@ -0,0 +1,388 @@
+package buckets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"b0k3ts/internal/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Credential sources for a connection. Static keys are the default and keep
+// using access_key_id / secret_access_key.
+const (
+	CredentialsStatic      = "static"
+	CredentialsAssumeRole  = "assume_role"
+	CredentialsWebIdentity = "web_identity"
+	CredentialsEnv         = "env"
+	CredentialsFile        = "file"
+	CredentialsIAM         = "iam"
+	CredentialsChain       = "chain"
+)
+
+// CredentialsConfig selects where a connection gets its S3 credentials from.
+// Everything except static keys and the session token lets us avoid storing
+// long-lived root keys in storage.
+type CredentialsConfig struct {
+	Type string `json:"type"` // static (default), assume_role, web_identity, env, file, iam or chain
+
+	// static: optional session token for temporary keys.
+	SessionToken string `json:"session_token,omitempty"`
+
+	// assume_role / web_identity. assume_role signs with access_key_id /
+	// secret_access_key, which should belong to a low-privilege user.
+	STSEndpoint     string `json:"sts_endpoint,omitempty"` // default: the connection endpoint
+	RoleARN         string `json:"role_arn,omitempty"`
+	RoleSessionName string `json:"role_session_name,omitempty"`
+	ExternalID      string `json:"external_id,omitempty"`
+	Policy          string `json:"policy,omitempty"`           // optional session policy
+	DurationSeconds int    `json:"duration_seconds,omitempty"` // default 3600
+
+	// file: AWS shared credentials file (profile) or MinIO client config (alias).
+	File       string `json:"file,omitempty"`
+	FileFormat string `json:"file_format,omitempty"` // "aws" (default) or "minio"
+	Profile    string `json:"profile,omitempty"`
+
+	// iam: optional metadata endpoint override.
+	IAMEndpoint string `json:"iam_endpoint,omitempty"`
+
+	// chain: providers tried in order; any of env, env_aws, env_minio, file, iam.
+	Chain []string `json:"chain,omitempty"`
+}
+
+// Long-lived credential objects, keyed by a fingerprint of the connection's
+// credential settings. minio-go refreshes them on its own before they expire,
+// but only if the same object is reused across requests.
+var credentialsCache sync.Map // string -> *credentials.Credentials
+
+// Per-user web identity credentials, keyed by connection fingerprint and user.
+var userCredentialsCache sync.Map // string -> userCredentials
+
+type userCredentials struct {
+	creds     *credentials.Credentials
+	expires   time.Time
+	tokenHash string // the verified token these were issued for
+}
+
+const (
+	// Re-exchange the token a little before the backend would refuse it.
+	webIdentityExpiryMargin = time.Minute
+	// Used when the STS response carries no expiry.
+	webIdentityFallbackTTL = 15 * time.Minute
+)
+
+// connectionCredentials returns the credentials for a connection.
+// web_identity depends on the caller and has its own per-user cache; its STS
+// call goes through tr so it honours the connection's TLS settings.
+func connectionCredentials(cfg BucketConfig, tr http.RoundTripper) (*credentials.Credentials, error) {
+	cc := cfg.Credentials
+	if cc == nil || cc.Type == "" || cc.Type == CredentialsStatic {
+		token := ""
+		if cc != nil {
+			token = cc.SessionToken
+		}
+		return credentials.NewStaticV4(cfg.AccessKeyId, cfg.SecretAccessKey, token), nil
+	}
+
+	if cc.Type == CredentialsWebIdentity {
+		return webIdentityCredentials(cfg, tr)
+	}
+
+	key := credentialsFingerprint(cfg)
+	if v, ok := credentialsCache.Load(key); ok {
+		return v.(*credentials.Credentials), nil
+	}
+
+	creds, err := newConnectionCredentials(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	v, _ := credentialsCache.LoadOrStore(key, creds)
+	return v.(*credentials.Credentials), nil
+}
+
+func newConnectionCredentials(cfg BucketConfig) (*credentials.Credentials, error) {
+	cc := cfg.Credentials
+
+	switch cc.Type {
+	case CredentialsAssumeRole:
+		return credentials.NewSTSAssumeRole(stsEndpoint(cfg), credentials.STSAssumeRoleOptions{
+			AccessKey:       cfg.AccessKeyId,
+			SecretKey:       cfg.SecretAccessKey,
+			SessionToken:    cc.SessionToken,
+			Policy:          cc.Policy,
+			Location:        cfg.Location,
+			DurationSeconds: cc.DurationSeconds,
+			RoleARN:         cc.RoleARN,
+			RoleSessionName: cc.RoleSessionName,
+			ExternalID:      cc.ExternalID,
+		})
+	case CredentialsEnv, CredentialsFile, CredentialsIAM:
+		p, err := credentialsProvider(cc.Type, cc)
+		if err != nil {
+			return nil, err
+		}
+		return credentials.New(p), nil
+	case CredentialsChain:
+		providers := make([]credentials.Provider, 0, len(cc.Chain))
+		for _, name := range cc.Chain {
+			p, err := credentialsProvider(name, cc)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, p)
+		}
+		return credentials.NewChainCredentials(providers), nil
+	default:
+		return nil, fmt.Errorf("credentials: unknown type %q", cc.Type)
+	}
+}
+
+func credentialsProvider(name string, cc *CredentialsConfig) (credentials.Provider, error) {
+	switch name {
+	case CredentialsEnv:
+		return &credentials.Chain{Providers: []credentials.Provider{&credentials.EnvAWS{}, &credentials.EnvMinio{}}}, nil
+	case "env_aws":
+		return &credentials.EnvAWS{}, nil
+	case "env_minio":
+		return &credentials.EnvMinio{}, nil
+	case CredentialsFile:
+		if cc.FileFormat == "minio" {
+			return &credentials.FileMinioClient{Filename: cc.File, Alias: cc.Profile}, nil
+		}
+		return &credentials.FileAWSCredentials{Filename: cc.File, Profile: cc.Profile}, nil
+	case CredentialsIAM:
+		return &credentials.IAM{Endpoint: cc.IAMEndpoint}, nil
+	default:
+		return nil, fmt.Errorf("credentials: unknown provider %q", name)
+	}
+}
+
+// webIdentityCredentials is the per-user pass-through mode: the caller's
+// verified OIDC token is exchanged for temporary credentials with
+// AssumeRoleWithWebIdentity, so the backend applies that user's own policy.
+// Credentials are cached per user and connection until shortly before they
+// expire.
+func webIdentityCredentials(cfg BucketConfig, tr http.RoundTripper) (*credentials.Credentials, error) {
+	if cfg.callerToken == "" {
+		return nil, httpError{status: 401, msg: "this connection needs a signed-in OIDC user"}
+	}
+
+	caller, _ := auth.TokenToUserData(cfg.callerToken)
+	if caller.ID == "" {
+		return nil, httpError{status: 401, msg: "this connection needs a signed-in OIDC user"}
+	}
+
+	// The user id comes from unverified claims, so a cached entry is only
+	// reused for the exact token that was verified when it was issued.
+	sum := sha256.Sum256([]byte(cfg.callerToken))
+	tokenHash := hex.EncodeToString(sum[:])
+
+	key := credentialsFingerprint(cfg) + "/" + caller.ID
+	if v, ok := userCredentialsCache.Load(key); ok {
+		entry := v.(userCredentials)
+		if entry.tokenHash == tokenHash && time.Now().Before(entry.expires) {
+			return entry.creds, nil
+		}
+	}
+
+	// Only tokens the provider signed may be exchanged; local b0k3ts tokens
+	// and forged claims stop here.
+	if cfg.oidcProviderURL == "" {
+		return nil, httpError{status: 401, msg: "web identity connections need OIDC to be configured"}
+	}
+	if _, err := auth.VerifyIDToken(cfg.oidcProviderURL, cfg.callerToken); err != nil {
+		slog.Error("web identity token verification failed", "user", caller.ID, "err", err)
+		return nil, httpError{status: 401, msg: "this connection needs a valid OIDC sign-in"}
+	}
+
+	cc := cfg.Credentials
+	token := cfg.callerToken
+
+	sts, err := credentials.NewSTSWebIdentity(stsEndpoint(cfg), func() (*credentials.WebIdentityToken, error) {
+		return &credentials.WebIdentityToken{Token: token, Expiry: cc.DurationSeconds}, nil
+	}, func(i *credentials.STSWebIdentity) {
+		i.RoleARN = cc.RoleARN
+		i.Policy = cc.Policy
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := sts.GetWithContext(&credentials.CredContext{Client: &http.Client{Transport: tr}})
+	if err != nil {
+		slog.Error("AssumeRoleWithWebIdentity failed", "user", caller.ID, "err", err)
+		return nil, httpError{status: 403, msg: "the storage backend refused this user's identity: " + err.Error()}
+	}
+
+	expires := time.Now().Add(webIdentityFallbackTTL)
+	if !v.Expiration.IsZero() {
+		expires = v.Expiration.Add(-webIdentityExpiryMargin)
+	}
+
+	creds := credentials.NewStaticV4(v.AccessKeyID, v.SecretAccessKey, v.SessionToken)
+	userCredentialsCache.Store(key, userCredentials{creds: creds, expires: expires, tokenHash: tokenHash})
+	pruneUserCredentials()
+
+	slog.Info("web identity credentials issued", "user", caller.ID, "bucket", cfg.BucketName, "expires", expires)
+	return creds, nil
+}
+
+// pruneUserCredentials drops expired entries so the cache doesn't grow with
+// every user who ever signed in.
+func pruneUserCredentials() {
+	now := time.Now()
+	userCredentialsCache.Range(func(k, v any) bool {
+		if now.After(v.(userCredentials).expires) {
+			userCredentialsCache.Delete(k)
+		}
+		return true
+	})
+}
+
+func stsEndpoint(cfg BucketConfig) string {
+	if cfg.Credentials != nil && cfg.Credentials.STSEndpoint != "" {
+		return cfg.Credentials.STSEndpoint
+	}
+	scheme := "http"
+	if cfg.Secure {
+		scheme = "https"
+	}
+	return scheme + "://" + cfg.Endpoint
+}
+
+// credentialsFingerprint changes whenever anything that affects the
+// credentials changes, so edited connections never reuse stale credentials.
+func credentialsFingerprint(cfg BucketConfig) string {
+	raw, _ := json.Marshal(struct {
+		Endpoint    string
+		Secure      bool
+		Location    string
+		Lookup      string
+		AccessKey   string
+		SecretKey   string
+		Credentials *CredentialsConfig
+		TLS         *TLSConfig
+	}{cfg.Endpoint, cfg.Secure, cfg.Location, cfg.BucketLookup, cfg.AccessKeyId, cfg.SecretAccessKey, cfg.Credentials, cfg.TLS})
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// validateCredentialsConfig checks a connection's credential settings.
+func validateCredentialsConfig(cfg BucketConfig) error {
+	cc := cfg.Credentials
+	if cc == nil {
+		return nil
+	}
+
+	needKeys := func() error {
+		if cfg.AccessKeyId == "" || cfg.SecretAccessKey == "" {
+			return fmt.Errorf("credentials: %s needs access_key_id and secret_access_key", cc.Type)
+		}
+		return nil
+	}
+	if cc.DurationSeconds < 0 || cc.DurationSeconds > 43200 {
+		return errors.New("credentials: duration_seconds must be between 0 and 43200")
+	}
+
+	switch cc.Type {
+	case "", CredentialsStatic:
+		return needKeys()
+	case CredentialsAssumeRole:
+		return needKeys()
+	case CredentialsWebIdentity:
+		return nil
+	case CredentialsEnv, CredentialsIAM:
+		return nil
+	case CredentialsFile:
+		if strings.TrimSpace(cc.File) == "" {
+			return errors.New("credentials: file is required")
+		}
+		return nil
+	case CredentialsChain:
+		if len(cc.Chain) == 0 {
+			return errors.New("credentials: chain needs at least one provider")
+		}
+		for _, name := range cc.Chain {
+			if _, err := credentialsProvider(name, cc); err != nil {
+				return err
+			}
+			if name == CredentialsFile && strings.TrimSpace(cc.File) == "" {
+				return errors.New("credentials: file is required")
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("credentials: unknown type %q", cc.Type)
+	}
+}
+
+// PerUser reports whether the connection acts as the calling user
+// (web_identity). Such a connection has no credentials of its own, so public
+// links, background jobs and backups can't use it.
+func (cfg BucketConfig) PerUser() bool {
+	return cfg.Credentials != nil && cfg.Credentials.Type == CredentialsWebIdentity
+}
+
+// errPerUser refuses a feature that runs without a signed-in caller.
+func errPerUser(feature string) error {
+	return httpError{status: 400, msg: feature + " can't use this connection: its web_identity credentials belong to whoever is signed in"}
+}
+
+// checkCredentialsSource refuses credential settings the caller may not
+// save through the API. env, file, iam and chain act with the server's own
+// identity (and file reads any path it can), and a custom iam_endpoint makes
+// the server call an arbitrary URL, so those belong in config.yaml only.
+// Other non-static types and a custom sts_endpoint need an administrator.
+func checkCredentialsSource(cfg BucketConfig, admin bool) error {
+	cc := cfg.Credentials
+	if cc == nil {
+		return nil
+	}
+
+	switch cc.Type {
+	case CredentialsEnv, CredentialsFile, CredentialsIAM, CredentialsChain:
+		return httpError{status: 403, msg: fmt.Sprintf("credentials: %s uses the server's own identity and can only be declared in config.yaml", cc.Type)}
+	}
+	if cc.IAMEndpoint != "" {
+		return httpError{status: 403, msg: "credentials: iam_endpoint can only be set in config.yaml"}
+	}
+
+	if admin {
+		return nil
+	}
+	if cc.Type != "" && cc.Type != CredentialsStatic {
+		return httpError{status: 403, msg: fmt.Sprintf("credentials: %s requires an administrator", cc.Type)}
+	}
+	if cc.STSEndpoint != "" {
+		return httpError{status: 403, msg: "credentials: sts_endpoint requires an administrator"}
+	}
+	return nil
+}
+
+// bearerToken strips an optional "Bearer " prefix.
+func bearerToken(header string) string {
+	raw := strings.TrimSpace(header)
+	if parts := strings.SplitN(raw, " ", 2); len(parts) == 2 && strings.EqualFold(parts[0], "Bearer") {
+		return strings.TrimSpace(parts[1])
+	}
+	return raw
+}
+
+// withCaller attaches the caller's token so web_identity connections can act
+// as that user.
+func (app *App) withCaller(c *gin.Context, cfg *BucketConfig) {
+	cfg.callerToken = bearerToken(c.GetHeader("Authorization"))
+	cfg.oidcProviderURL = app.OIDCConfig.ProviderUrl
+}
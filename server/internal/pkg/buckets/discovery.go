@@ -0,0 +1,480 @@
+package buckets
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"b0k3ts/internal/pkg/audit"
+	"b0k3ts/internal/pkg/auth"
+	"b0k3ts/internal/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+const EndpointIdPrefix = storage.NSEndpoints
+
+// EndpointConfig is an S3 endpoint and its credentials, registered once so
+// its buckets can be discovered and imported as connections.
+type EndpointConfig struct {
+	Name            string             `json:"name"`
+	Endpoint        string             `json:"endpoint"`
+	AccessKeyId     string             `json:"access_key_id"`
+	SecretAccessKey string             `json:"secret_access_key"`
+	Secure          bool               `json:"secure"`
+	Location        string             `json:"location,omitempty"` // default region for new buckets
+	BucketLookup    string             `json:"bucket_lookup,omitempty"`
+	Credentials     *CredentialsConfig `json:"credentials,omitempty"`
+	TLS             *TLSConfig         `json:"tls,omitempty"`
+}
+
+type EndpointRequest struct {
+	Name string `json:"name"`
+}
+
+// DiscoveredBucket is a bucket visible to an endpoint's credentials.
+type DiscoveredBucket struct {
+	Name      string `json:"name"`
+	Connected bool   `json:"connected"` // a connection with this bucket name already exists
+}
+
+type EndpointImportRequest struct {
+	Name             string   `json:"name"`    // endpoint
+	Buckets          []string `json:"buckets"` // buckets to create connections for
+	AuthorizedUsers  []string `json:"authorized_users"`
+	AuthorizedGroups []string `json:"authorized_groups"`
+	Overwrite        bool     `json:"overwrite,omitempty"` // replace existing connections
+}
+
+type EndpointImportResult struct {
+	Bucket string `json:"bucket"`
+	Status string `json:"status"` // created, replaced, skipped or failed
+	Error  string `json:"error,omitempty"`
+}
+
+type BucketCreateRequest struct {
+	Endpoint      string `json:"endpoint"` // registered endpoint name
+	Bucket        string `json:"bucket"`
+	Region        string `json:"region,omitempty"` // default: the endpoint location
+	ObjectLocking bool   `json:"object_locking,omitempty"`
+	Versioning    bool   `json:"versioning,omitempty"` // implied by object_locking
+
+	// Optionally add a connection for the new bucket.
+	Connect          bool     `json:"connect,omitempty"`
+	AuthorizedUsers  []string `json:"authorized_users,omitempty"`
+	AuthorizedGroups []string `json:"authorized_groups,omitempty"`
+}
+
+// bucketConfig returns a connection for bucket on this endpoint.
+func (e EndpointConfig) bucketConfig(bucket string) BucketConfig {
+	return BucketConfig{
+		BucketId:        bucket,
+		Endpoint:        e.Endpoint,
+		AccessKeyId:     e.AccessKeyId,
+		SecretAccessKey: e.SecretAccessKey,
+		Secure:          e.Secure,
+		BucketName:      bucket,
+		Location:        e.Location,
+		BucketLookup:    e.BucketLookup,
+		Credentials:     e.Credentials,
+		TLS:             e.TLS,
+	}
+}
+
+// redacted returns a copy of the endpoint with its secrets replaced by
+// redactedSecret, like BucketConfig.redacted.
+func (e EndpointConfig) redacted() EndpointConfig {
+	r := e.bucketConfig("").redacted()
+	e.SecretAccessKey, e.TLS, e.Credentials = r.SecretAccessKey, r.TLS, r.Credentials
+	return e
+}
+
+// restoreRedactedEndpointSecrets puts the stored endpoint's secrets back where a
+// replacement sent redactedSecret, as long as it points at the same server.
+func (app *App) restoreRedactedEndpointSecrets(ep *EndpointConfig) error {
+	cfg := ep.bucketConfig("")
+	if !hasRedactedSecrets(cfg) {
+		return nil
+	}
+
+	old, err := endpointRepo(app.DB).Get(ep.Name)
+	if storage.IsNotFound(err) {
+		return httpError{status: 400, msg: "send the secrets in full: there is no stored endpoint to keep them from"}
+	}
+	if err != nil {
+		return err
+	}
+	if old.Endpoint != ep.Endpoint {
+		return httpError{status: 400, msg: "send the secrets in full: stored ones are only kept for the same endpoint"}
+	}
+	if err := keepSecrets(&cfg, old.bucketConfig("")); err != nil {
+		return err
+	}
+	ep.SecretAccessKey, ep.TLS, ep.Credentials = cfg.SecretAccessKey, cfg.TLS, cfg.Credentials
+	return nil
+}
+
+// AddEndpoint registers (or replaces) an endpoint. Admin only.
+func (app *App) AddEndpoint(c *gin.Context) {
+	userInfo, ok := auth.VerifiedAdminOrRespond(c)
+	if !ok {
+		return
+	}
+
+	var ep EndpointConfig
+	if err := c.ShouldBindJSON(&ep); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	ep.Name = strings.TrimSpace(ep.Name)
+	if ep.Name == "" || ep.Endpoint == "" {
+		c.JSON(400, gin.H{"error": "name and endpoint are required"})
+		return
+	}
+	if err := app.restoreRedactedEndpointSecrets(&ep); err != nil {
+		respondError(c, err)
+		return
+	}
+	if err := validateBucketConfig(ep.bucketConfig("")); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	// Imported connections are shared with non-admins.
+	if err := checkCredentialsSource(ep.bucketConfig(""), true); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if err := endpointRepo(app.DB).Put(ep.Name, ep); err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	details := map[string]string{"endpoint": ep.Endpoint}
+	if ep.TLS != nil && ep.TLS.InsecureSkipVerify {
+		details["insecure_skip_verify"] = "true"
+	}
+	if err := audit.Record(app.DB, userInfo.Email, "endpoint_add", ep.Name, details); err != nil {
+		slog.Error("failed to record endpoint audit event", "err", err)
+	}
+
+	c.JSON(200, gin.H{"message": "Endpoint Added"})
+}
+
+// ListEndpoints returns the registered endpoints with their secrets
+// redacted. Admin only.
+func (app *App) ListEndpoints(c *gin.Context) {
+	if _, ok := auth.VerifiedAdminOrRespond(c); !ok {
+		return
+	}
+
+	endpoints, err := endpointRepo(app.DB).List()
+	if err != nil {
+		slog.Error("failed to list endpoints", "err", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	out := make([]EndpointConfig, 0, len(endpoints))
+	for _, ep := range endpoints {
+		out = append(out, ep.redacted())
+	}
+	c.JSON(200, out)
+}
+
+// DeleteEndpoint removes an endpoint. Connections imported from it are kept.
+func (app *App) DeleteEndpoint(c *gin.Context) {
+	userInfo, ok := auth.VerifiedAdminOrRespond(c)
+	if !ok {
+		return
+	}
+
+	var req EndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := app.endpointOrRespond(c, req.Name); !ok {
+		return
+	}
+
+	if err := endpointRepo(app.DB).Delete(req.Name); err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := audit.Record(app.DB, userInfo.Email, "endpoint_delete", req.Name, nil); err != nil {
+		slog.Error("failed to record endpoint audit event", "err", err)
+	}
+
+	c.JSON(200, gin.H{"message": "Endpoint deleted successfully"})
+}
+
+// DiscoverBuckets lists every bucket the endpoint's credentials can see.
+func (app *App) DiscoverBuckets(c *gin.Context) {
+	if _, ok := auth.VerifiedAdminOrRespond(c); !ok {
+		return
+	}
+
+	var req EndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	ep, ok := app.endpointOrRespond(c, req.Name)
+	if !ok {
+		return
+	}
+
+	cfg := ep.bucketConfig("")
+	app.withCaller(c, &cfg)
+
+	mio, closeFn, err := connectEndpoint(cfg)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	defer closeFn()
+
+	names, err := mio.ListBuckets()
+	if err != nil {
+		c.JSON(502, gin.H{"error": err.Error()})
+		return
+	}
+	sort.Strings(names)
+
+	out := make([]DiscoveredBucket, 0, len(names))
+	for _, name := range names {
+		out = append(out, DiscoveredBucket{Name: name, Connected: app.connectionExists(name)})
+	}
+	c.JSON(200, out)
+}
+
+// ImportBuckets creates connections for the selected buckets of an endpoint,
+// all with the same authorization. Existing connections are skipped unless
+// overwrite is set.
+func (app *App) ImportBuckets(c *gin.Context) {
+	userInfo, ok := auth.VerifiedAdminOrRespond(c)
+	if !ok {
+		return
+	}
+
+	var req EndpointImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Buckets) == 0 {
+		c.JSON(400, gin.H{"error": "buckets is required"})
+		return
+	}
+	ep, ok := app.endpointOrRespond(c, req.Name)
+	if !ok {
+		return
+	}
+
+	// Buckets on AWS can live in any region; look each one up unless the
+	// endpoint pins a location.
+	var mio *Buckets
+	if ep.Location == "" {
+		cfg := ep.bucketConfig("")
+		app.withCaller(c, &cfg)
+		client, closeFn, err := connectEndpoint(cfg)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer closeFn()
+		mio = client
+	}
+
+	results := make([]EndpointImportResult, 0, len(req.Buckets))
+	imported := make([]string, 0, len(req.Buckets))
+	for _, bucket := range req.Buckets {
+		bucket = strings.TrimSpace(bucket)
+		if bucket == "" {
+			continue
+		}
+
+		exists := app.connectionExists(bucket)
+		if exists && !req.Overwrite {
+			results = append(results, EndpointImportResult{Bucket: bucket, Status: "skipped", Error: "connection already exists"})
+			continue
+		}
+
+		cfg := ep.bucketConfig(bucket)
+		cfg.AuthorizedUsers = req.AuthorizedUsers
+		cfg.AuthorizedGroups = req.AuthorizedGroups
+		if mio != nil {
+			cfg.Location = detectRegion(mio.Client, bucket)
+		}
+
+		if _, err := saveBucketConfig(app.DB, &cfg); err != nil {
+			slog.Error("failed to import bucket connection", "bucket", bucket, "err", err)
+			results = append(results, EndpointImportResult{Bucket: bucket, Status: "failed", Error: err.Error()})
+			continue
+		}
+
+		status := "created"
+		if exists {
+			status = "replaced"
+		}
+		results = append(results, EndpointImportResult{Bucket: bucket, Status: status})
+		imported = append(imported, bucket)
+	}
+
+	if len(imported) > 0 {
+		if err := audit.Record(app.DB, userInfo.Email, "endpoint_import", req.Name, map[string]string{
+			"buckets": strings.Join(imported, ","),
+			"users":   strings.Join(req.AuthorizedUsers, ","),
+			"groups":  strings.Join(req.AuthorizedGroups, ","),
+		}); err != nil {
+			slog.Error("failed to record endpoint audit event", "err", err)
+		}
+	}
+
+	c.JSON(200, gin.H{"results": results})
+}
+
+// CreateBucket creates a bucket on a registered endpoint and can add a
+// connection for it. Admin only.
+func (app *App) CreateBucket(c *gin.Context) {
+	userInfo, ok := auth.VerifiedAdminOrRespond(c)
+	if !ok {
+		return
+	}
+
+	var req BucketCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	req.Bucket = strings.TrimSpace(req.Bucket)
+	if req.Bucket == "" {
+		c.JSON(400, gin.H{"error": "bucket is required"})
+		return
+	}
+	if req.Connect && app.connectionExists(req.Bucket) {
+		c.JSON(409, gin.H{"error": "a connection for this bucket name already exists"})
+		return
+	}
+
+	ep, ok := app.endpointOrRespond(c, req.Endpoint)
+	if !ok {
+		return
+	}
+
+	cfg := ep.bucketConfig("")
+	app.withCaller(c, &cfg)
+	mio, closeFn, err := connectEndpoint(cfg)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	defer closeFn()
+
+	region := req.Region
+	if region == "" {
+		region = ep.Location
+	}
+
+	ctx := context.Background()
+	err = mio.Client.MakeBucket(ctx, req.Bucket, minio.MakeBucketOptions{
+		Region:        region,
+		ObjectLocking: req.ObjectLocking,
+	})
+	if err != nil {
+		slog.Error("failed to create bucket", "bucket", req.Bucket, "err", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Object locking turns versioning on by itself.
+	if req.Versioning && !req.ObjectLocking {
+		if err := mio.Client.EnableVersioning(ctx, req.Bucket); err != nil {
+			slog.Error("failed to enable versioning", "bucket", req.Bucket, "err", err)
+			c.JSON(400, gin.H{"error": "bucket created but versioning could not be enabled: " + err.Error()})
+			return
+		}
+	}
+
+	if err := audit.Record(app.DB, userInfo.Email, "bucket_create", req.Bucket, map[string]string{
+		"endpoint":       req.Endpoint,
+		"region":         region,
+		"object_locking": fmt.Sprint(req.ObjectLocking),
+		"versioning":     fmt.Sprint(req.Versioning || req.ObjectLocking),
+	}); err != nil {
+		slog.Error("failed to record bucket audit event", "err", err)
+	}
+
+	if req.Connect {
+		conn := ep.bucketConfig(req.Bucket)
+		conn.Location = region
+		conn.AuthorizedUsers = req.AuthorizedUsers
+		conn.AuthorizedGroups = req.AuthorizedGroups
+		if _, err := saveBucketConfig(app.DB, &conn); err != nil {
+			slog.Error(err.Error())
+			c.JSON(400, gin.H{"error": "bucket created but the connection could not be saved: " + err.Error()})
+			return
+		}
+	}
+
+	c.JSON(200, gin.H{"message": "Bucket created"})
+}
+
+func (app *App) endpointOrRespond(c *gin.Context, name string) (EndpointConfig, bool) {
+	if strings.TrimSpace(name) == "" {
+		c.JSON(400, gin.H{"error": "endpoint name is required"})
+		return EndpointConfig{}, false
+	}
+
+	ep, err := endpointRepo(app.DB).Get(name)
+	if storage.IsNotFound(err) {
+		c.JSON(404, gin.H{"error": "endpoint not found"})
+		return EndpointConfig{}, false
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return EndpointConfig{}, false
+	}
+	return ep, true
+}
+
+// endpointRepo stores endpoints keyed by name.
+func endpointRepo(db storage.Store) storage.Repository[EndpointConfig] {
+	return storage.NewRepository[EndpointConfig](db, EndpointIdPrefix)
+}
+
+func (app *App) connectionExists(bucket string) bool {
+	ok, err := connectionRepo(app.DB).Exists(bucket)
+	if err != nil {
+		slog.Error("failed to look up connection", "bucket", bucket, "err", err)
+	}
+	return ok
+}
+
+// connectEndpoint builds a one-off client for an endpoint. Endpoint clients
+// aren't pooled; close releases their connections.
+func connectEndpoint(cfg BucketConfig) (*Buckets, func(), error) {
+	transport, err := newClientTransport(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	creds, err := connectionCredentials(cfg, transport)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := newS3Client(cfg, creds, transport, cfg.Location)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &Buckets{Client: client, Config: cfg}, transport.CloseIdleConnections, nil
+}
@@ -0,0 +1,667 @@
+package buckets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"b0k3ts/internal/pkg/notify"
+	"b0k3ts/internal/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const UploadRequestPrefix = storage.NSUploadRequests
+
+const (
+	uploadRequestDefaultExpiry    = 7 * 24 * time.Hour
+	uploadRequestMaxExpiry        = 90 * 24 * time.Hour
+	uploadRequestDefaultFileBytes = 1 << 30 // 1 GiB
+	uploadRequestDefaultMaxFiles  = 10
+
+	// Pending uploads older than this no longer hold a file slot or their
+	// key, and are aborted the next time someone starts an upload.
+	uploadRequestPendingTTL = 24 * time.Hour
+)
+
+var (
+	ErrUploadRequestNotFound = errors.New("upload link not found")
+	ErrUploadRequestExpired  = errors.New("upload link expired")
+	ErrUploadRequestRevoked  = errors.New("upload link revoked")
+	ErrUploadRequestFull     = errors.New("upload link file limit reached")
+	ErrUploadRequestPassword = errors.New("upload link password required or invalid")
+	ErrUploadUnknown         = errors.New("unknown upload for this link")
+	ErrUploadKeyTaken        = errors.New("a file with this name is already being uploaded")
+)
+
+// UploadRequest is an upload-only "drop-box" link scoped to a prefix.
+// Anyone holding the token can upload (not list or download) files there.
+type UploadRequest struct {
+	Token        string                   `json:"token"`
+	Bucket       string                   `json:"bucket"` // bucket connection id
+	Prefix       string                   `json:"prefix"`
+	Message      string                   `json:"message,omitempty"` // shown to the uploader
+	CreatedBy    string                   `json:"created_by"`
+	CreatedAt    time.Time                `json:"created_at"`
+	ExpiresAt    time.Time                `json:"expires_at"`
+	PasswordHash string                   `json:"password_hash,omitempty"` // bcrypt
+	MaxFileBytes int64                    `json:"max_file_bytes"`
+	MaxFiles     int                      `json:"max_files"`
+	Pending      map[string]PendingUpload `json:"pending,omitempty"` // keyed by upload id
+	Uploads      []CompletedUpload        `json:"uploads"`
+	Revoked      bool                     `json:"revoked"`
+}
+
+type PendingUpload struct {
+	Key       string    `json:"key"`
+	Uploader  string    `json:"uploader,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+type CompletedUpload struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	Uploader    string    `json:"uploader,omitempty"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+type UploadRequestView struct {
+	Token        string            `json:"token"`
+	URL          string            `json:"url"`
+	Bucket       string            `json:"bucket"`
+	Prefix       string            `json:"prefix"`
+	Message      string            `json:"message,omitempty"`
+	CreatedBy    string            `json:"created_by"`
+	CreatedAt    time.Time         `json:"created_at"`
+	ExpiresAt    time.Time         `json:"expires_at"`
+	HasPassword  bool              `json:"has_password"`
+	MaxFileBytes int64             `json:"max_file_bytes"`
+	MaxFiles     int               `json:"max_files"`
+	Uploads      []CompletedUpload `json:"uploads"`
+	Revoked      bool              `json:"revoked"`
+	Expired      bool              `json:"expired"`
+}
+
+// UploadRequestInfo is what an anonymous uploader gets to see.
+type UploadRequestInfo struct {
+	Message        string    `json:"message,omitempty"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	MaxFileBytes   int64     `json:"max_file_bytes"`
+	RemainingFiles int       `json:"remaining_files"`
+}
+
+type UploadRequestCreateRequest struct {
+	Bucket         string `json:"bucket"`
+	Prefix         string `json:"prefix"`
+	Message        string `json:"message,omitempty"`
+	ExpiresSeconds int64  `json:"expires_seconds,omitempty"` // optional; default 7 days, max 90 days
+	Password       string `json:"password,omitempty"`        // optional
+	MaxFileBytes   int64  `json:"max_file_bytes,omitempty"`  // optional; default 1 GiB
+	MaxFiles       int    `json:"max_files,omitempty"`       // optional; default 10
+}
+
+type UploadRequestRevokeRequest struct {
+	Token string `json:"token"`
+}
+
+type DropboxInitiateRequest struct {
+	Filename    string `json:"filename"`               // stored as <prefix><filename>
+	Size        int64  `json:"size"`                   // declared size, checked against the link limit
+	ContentType string `json:"content_type,omitempty"` // optional
+	Uploader    string `json:"uploader,omitempty"`     // optional name/email of the sender
+}
+
+func (u UploadRequest) toView() UploadRequestView {
+	return UploadRequestView{
+		Token:        u.Token,
+		URL:          "/d/" + u.Token,
+		Bucket:       u.Bucket,
+		Prefix:       u.Prefix,
+		Message:      u.Message,
+		CreatedBy:    u.CreatedBy,
+		CreatedAt:    u.CreatedAt,
+		ExpiresAt:    u.ExpiresAt,
+		HasPassword:  u.PasswordHash != "",
+		MaxFileBytes: u.MaxFileBytes,
+		MaxFiles:     u.MaxFiles,
+		Uploads:      u.Uploads,
+		Revoked:      u.Revoked,
+		Expired:      time.Now().After(u.ExpiresAt),
+	}
+}
+
+func (u UploadRequest) remainingFiles() int {
+	n := u.MaxFiles - len(u.Uploads)
+	for _, p := range u.Pending {
+		if !p.stale() {
+			n--
+		}
+	}
+	return n
+}
+
+func (p PendingUpload) stale() bool {
+	return time.Since(p.StartedAt) > uploadRequestPendingTTL
+}
+
+// dropStalePending removes abandoned uploads and returns them, keyed by
+// upload id, so their multipart uploads can be aborted.
+func (u *UploadRequest) dropStalePending() map[string]PendingUpload {
+	stale := make(map[string]PendingUpload)
+	for id, p := range u.Pending {
+		if p.stale() {
+			stale[id] = p
+			delete(u.Pending, id)
+		}
+	}
+	return stale
+}
+
+// --- Authenticated management ---
+
+func (app *App) CreateUploadRequest(c *gin.Context) {
+	var req UploadRequestCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Error("create upload link failed. failed to bind json", "err", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Bucket == "" {
+		c.JSON(400, gin.H{"error": "bucket is required"})
+		return
+	}
+	if req.MaxFileBytes < 0 || req.MaxFiles < 0 {
+		c.JSON(400, gin.H{"error": "limits must not be negative"})
+		return
+	}
+
+	expires := time.Duration(req.ExpiresSeconds) * time.Second
+	if expires <= 0 {
+		expires = uploadRequestDefaultExpiry
+	}
+	if expires > uploadRequestMaxExpiry {
+		c.JSON(400, gin.H{"error": "expires_seconds too large"})
+		return
+	}
+
+	userInfo, ok := tokenUserOrRespond(c)
+	if !ok {
+		return
+	}
+
+	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
+	if bucketConfig == nil {
+		return
+	}
+	if bucketConfig.PerUser() {
+		respondError(c, errPerUser("upload links"))
+		return
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now().UTC()
+	link := UploadRequest{
+		Token:        token,
+		Bucket:       req.Bucket,
+		Prefix:       normalizePrefix(strings.TrimPrefix(req.Prefix, "/")),
+		Message:      req.Message,
+		CreatedBy:    userInfo.Email,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(expires),
+		MaxFileBytes: req.MaxFileBytes,
+		MaxFiles:     req.MaxFiles,
+		Uploads:      make([]CompletedUpload, 0),
+	}
+	if link.MaxFileBytes == 0 {
+		link.MaxFileBytes = uploadRequestDefaultFileBytes
+	}
+	if link.MaxFiles == 0 {
+		link.MaxFiles = uploadRequestDefaultMaxFiles
+	}
+
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			slog.Error(err.Error())
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		link.PasswordHash = string(hash)
+	}
+
+	if err := uploadRequestRepo(app.DB).Put(token, link); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, link.toView())
+}
+
+// ListUploadRequests returns the caller's upload links, including what has
+// been uploaded through them. Administrators may pass all=true.
+func (app *App) ListUploadRequests(c *gin.Context) {
+	userInfo, ok := tokenUserOrRespond(c)
+	if !ok {
+		return
+	}
+	all := userInfo.Administrator && c.Query("all") == "true"
+
+	stored, err := uploadRequestRepo(app.DB).List()
+	if err != nil {
+		slog.Error("failed to list upload requests", "err", err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	links := make([]UploadRequestView, 0)
+	for _, link := range stored {
+		if all || link.CreatedBy == userInfo.Email {
+			links = append(links, link.toView())
+		}
+	}
+
+	sort.Slice(links, func(i, j int) bool { return links[i].CreatedAt.After(links[j].CreatedAt) })
+
+	c.JSON(200, links)
+}
+
+func (app *App) RevokeUploadRequest(c *gin.Context) {
+	userInfo, ok := tokenUserOrRespond(c)
+	if !ok {
+		return
+	}
+
+	var req UploadRequestRevokeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(400, gin.H{"error": "token is required"})
+		return
+	}
+
+	err := app.updateUploadRequest(req.Token, func(link *UploadRequest) error {
+		if link.CreatedBy != userInfo.Email && !userInfo.Administrator {
+			return httpError{status: 403, msg: "not allowed"}
+		}
+		link.Revoked = true
+		return nil
+	})
+	if err != nil {
+		respondDropboxError(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "upload link revoked"})
+}
+
+// --- Unauthenticated drop-box flow ---
+
+// DropboxInfo lets the upload page show the link's message and limits.
+func (app *App) DropboxInfo(c *gin.Context) {
+	link, err := app.openUploadRequest(c)
+	if err != nil {
+		respondDropboxError(c, err)
+		return
+	}
+
+	c.JSON(200, UploadRequestInfo{
+		Message:        link.Message,
+		ExpiresAt:      link.ExpiresAt,
+		MaxFileBytes:   link.MaxFileBytes,
+		RemainingFiles: max(0, link.remainingFiles()),
+	})
+}
+
+func (app *App) DropboxInitiate(c *gin.Context) {
+	var req DropboxInitiateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	link, err := app.openUploadRequest(c)
+	if err != nil {
+		respondDropboxError(c, err)
+		return
+	}
+
+	rel, err := sanitizeEntryName(req.Filename)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid filename: " + err.Error()})
+		return
+	}
+	if req.Size <= 0 || req.Size > link.MaxFileBytes {
+		c.JSON(413, gin.H{"error": fmt.Sprintf("file size must be between 1 and %d bytes", link.MaxFileBytes)})
+		return
+	}
+	if link.remainingFiles() <= 0 {
+		respondDropboxError(c, ErrUploadRequestFull)
+		return
+	}
+
+	bucketConfig, ok := getBucketConfigOrRespond(c, app.DB, link.Bucket)
+	if !ok {
+		return
+	}
+
+	mio, err := Connect(bucketConfig)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// Uploaders can't see the prefix, so never let them replace what's there.
+	key := link.Prefix + rel
+	if err := ensureDestinationAbsent(context.Background(), mio, bucketConfig.BucketName, key, false); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// Drop-box uploads always use the connection's default encryption.
+	sse, err := uploadEncryption(bucketConfig, nil)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	uploadID, err := multipartInitiate(bucketConfig, key, req.ContentType, sse)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	var stale map[string]PendingUpload
+	err = app.updateUploadRequest(link.Token, func(l *UploadRequest) error {
+		stale = l.dropStalePending()
+		if l.remainingFiles() <= 0 {
+			return ErrUploadRequestFull
+		}
+		// The pending entry reserves the key until the upload completes.
+		for _, p := range l.Pending {
+			if p.Key == key {
+				return ErrUploadKeyTaken
+			}
+		}
+		if l.Pending == nil {
+			l.Pending = make(map[string]PendingUpload)
+		}
+		l.Pending[uploadID] = PendingUpload{Key: key, Uploader: req.Uploader, StartedAt: time.Now().UTC()}
+		return nil
+	})
+	if err != nil {
+		// The transaction didn't commit, so nothing stale was dropped either.
+		_ = multipartAbort(bucketConfig, key, uploadID)
+		respondDropboxError(c, err)
+		return
+	}
+	for id, p := range stale {
+		if err := multipartAbort(bucketConfig, p.Key, id); err != nil {
+			slog.Error("failed to abort stale drop-box upload", "key", p.Key, "err", err)
+		}
+	}
+
+	c.JSON(200, MultipartInitiateResponse{Key: key, UploadID: uploadID})
+}
+
+func (app *App) DropboxPresignPart(c *gin.Context) {
+	var req MultipartPresignPartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	req.SSECustomerKey = "" // drop-box uploads never use SSE-C
+	if req.PartNumber < 1 || req.PartNumber > 10000 {
+		c.JSON(400, gin.H{"error": "part_number must be between 1 and 10000"})
+		return
+	}
+
+	_, bucketConfig, ok := app.dropboxPendingOrRespond(c, req.Key, req.UploadID)
+	if !ok {
+		return
+	}
+
+	res, err := multipartPresignPart(bucketConfig, req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(200, res)
+}
+
+func (app *App) DropboxComplete(c *gin.Context) {
+	var req MultipartCompleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	req.SSECustomerKey = "" // drop-box uploads never use SSE-C
+	if len(req.Parts) == 0 {
+		c.JSON(400, gin.H{"error": "parts is required"})
+		return
+	}
+
+	link, bucketConfig, ok := app.dropboxPendingOrRespond(c, req.Key, req.UploadID)
+	if !ok {
+		return
+	}
+
+	mio, err := Connect(bucketConfig)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	ctx := context.Background()
+
+	// Someone may have written the key through the normal API since the
+	// upload started.
+	if err := ensureDestinationAbsent(ctx, mio, bucketConfig.BucketName, req.Key, false); err != nil {
+		_ = multipartAbort(bucketConfig, req.Key, req.UploadID)
+		_ = app.updateUploadRequest(link.Token, func(l *UploadRequest) error {
+			delete(l.Pending, req.UploadID)
+			return nil
+		})
+		respondError(c, err)
+		return
+	}
+
+	if err := multipartComplete(bucketConfig, req); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	st, err := mio.StatObject(ctx, bucketConfig.BucketName, req.Key, minio.StatObjectOptions{})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// The declared size was only a hint; enforce the limit on what actually landed.
+	tooLarge := st.Size > link.MaxFileBytes
+	if tooLarge {
+		if err := mio.RemoveObject(ctx, bucketConfig.BucketName, req.Key, minio.RemoveObjectOptions{}); err != nil {
+			slog.Error("failed to remove oversized drop-box upload", "key", req.Key, "err", err)
+		}
+	}
+
+	var uploader string
+	err = app.updateUploadRequest(link.Token, func(l *UploadRequest) error {
+		uploader = l.Pending[req.UploadID].Uploader
+		delete(l.Pending, req.UploadID)
+		if tooLarge {
+			return nil
+		}
+		l.Uploads = append(l.Uploads, CompletedUpload{
+			Key:         req.Key,
+			Size:        st.Size,
+			Uploader:    uploader,
+			CompletedAt: time.Now().UTC(),
+		})
+		return nil
+	})
+	if err != nil {
+		respondDropboxError(c, err)
+		return
+	}
+
+	if tooLarge {
+		c.JSON(413, gin.H{"error": fmt.Sprintf("file exceeds the %d byte limit", link.MaxFileBytes)})
+		return
+	}
+
+	publishObjectEvent(link.Bucket, EventObjectCreated, req.Key, uploader, st.Size)
+
+	from := uploader
+	if from == "" {
+		from = "someone"
+	}
+	err = notify.Notify(app.DB, link.CreatedBy, "dropbox_upload",
+		fmt.Sprintf("%s uploaded %s via your upload link", from, req.Key),
+		map[string]string{"bucket": link.Bucket, "key": req.Key, "token": link.Token, "size": fmt.Sprint(st.Size)},
+	)
+	if err != nil {
+		slog.Error("failed to notify upload link creator", "err", err)
+	}
+
+	c.JSON(200, gin.H{"message": "Multipart upload completed", "key": req.Key})
+}
+
+func (app *App) DropboxAbort(c *gin.Context) {
+	var req MultipartAbortRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	link, bucketConfig, ok := app.dropboxPendingOrRespond(c, req.Key, req.UploadID)
+	if !ok {
+		return
+	}
+
+	if err := multipartAbort(bucketConfig, req.Key, req.UploadID); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	err := app.updateUploadRequest(link.Token, func(l *UploadRequest) error {
+		delete(l.Pending, req.UploadID)
+		return nil
+	})
+	if err != nil {
+		respondDropboxError(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Multipart upload aborted"})
+}
+
+// --- Helpers ---
+
+// openUploadRequest loads the link named by the :token param and checks that
+// it's usable (not revoked or expired, password matches).
+func (app *App) openUploadRequest(c *gin.Context) (*UploadRequest, error) {
+	token := c.Param("token")
+	if strings.TrimSpace(token) == "" {
+		return nil, ErrUploadRequestNotFound
+	}
+
+	link, err := uploadRequestRepo(app.DB).Get(token)
+	if err != nil {
+		if storage.IsNotFound(err) {
+			return nil, ErrUploadRequestNotFound
+		}
+		return nil, err
+	}
+
+	if link.Revoked {
+		return nil, ErrUploadRequestRevoked
+	}
+	if time.Now().After(link.ExpiresAt) {
+		return nil, ErrUploadRequestExpired
+	}
+	if link.PasswordHash != "" {
+		password := c.GetHeader(SharePasswordHeader)
+		if password == "" || bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			return nil, ErrUploadRequestPassword
+		}
+	}
+
+	return &link, nil
+}
+
+// dropboxPendingOrRespond makes sure key/uploadID belong to an upload started
+// through this link, which also keeps the caller inside the link's prefix.
+func (app *App) dropboxPendingOrRespond(c *gin.Context, key, uploadID string) (*UploadRequest, BucketConfig, bool) {
+	link, err := app.openUploadRequest(c)
+	if err != nil {
+		respondDropboxError(c, err)
+		return nil, BucketConfig{}, false
+	}
+
+	pending, ok := link.Pending[uploadID]
+	if uploadID == "" || !ok || pending.stale() || pending.Key != key || !strings.HasPrefix(key, link.Prefix) {
+		respondDropboxError(c, ErrUploadUnknown)
+		return nil, BucketConfig{}, false
+	}
+
+	bucketConfig, ok := getBucketConfigOrRespond(c, app.DB, link.Bucket)
+	if !ok {
+		return nil, BucketConfig{}, false
+	}
+
+	return link, bucketConfig, true
+}
+
+// updateUploadRequest applies fn to the stored link inside one transaction.
+func (app *App) updateUploadRequest(token string, fn func(*UploadRequest) error) error {
+	return app.DB.Update(func(tx storage.Tx) error {
+		links := uploadRequestRepo(app.DB).Tx(tx)
+		link, err := links.Get(token)
+		if err != nil {
+			if storage.IsNotFound(err) {
+				return ErrUploadRequestNotFound
+			}
+			return err
+		}
+		if err := fn(&link); err != nil {
+			return err
+		}
+		return links.Put(token, link)
+	})
+}
+
+// uploadRequestRepo stores upload links keyed by token.
+func uploadRequestRepo(db storage.Store) storage.Repository[UploadRequest] {
+	return storage.NewRepository[UploadRequest](db, UploadRequestPrefix)
+}
+
+func respondDropboxError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrUploadRequestNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrUploadRequestPassword):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "password_required": true})
+	case errors.Is(err, ErrUploadRequestExpired), errors.Is(err, ErrUploadRequestRevoked):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrUploadRequestFull):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrUploadUnknown):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrUploadKeyTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		respondError(c, err)
+	}
+}
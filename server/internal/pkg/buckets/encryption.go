@@ -0,0 +1,250 @@
+package buckets
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// Encryption modes. SSE-C can only be chosen per request: the key belongs to
+// the caller and is never stored.
+const (
+	EncryptionNone   = "none"
+	EncryptionSSES3  = "SSE-S3"
+	EncryptionSSEKMS = "SSE-KMS"
+	EncryptionSSEC   = "SSE-C"
+
+	// SSECustomerKeyHeader carries a base64 SSE-C key on GET downloads, where
+	// there is no JSON body.
+	SSECustomerKeyHeader = "X-SSE-Customer-Key"
+)
+
+// EncryptionConfig is a connection's default encryption for new objects.
+type EncryptionConfig struct {
+	Mode       string            `json:"mode,omitempty"` // none (default), SSE-S3 or SSE-KMS
+	KMSKeyID   string            `json:"kms_key_id,omitempty"`
+	KMSContext map[string]string `json:"kms_context,omitempty"`
+}
+
+// EncryptionOverride replaces the connection default for one upload.
+type EncryptionOverride struct {
+	Mode        string            `json:"mode"` // none, SSE-S3, SSE-KMS or SSE-C
+	KMSKeyID    string            `json:"kms_key_id,omitempty"`
+	KMSContext  map[string]string `json:"kms_context,omitempty"`
+	CustomerKey string            `json:"customer_key,omitempty"` // SSE-C: base64 256-bit key
+}
+
+// ObjectEncryption is the encryption state reported by object stat.
+type ObjectEncryption struct {
+	Mode           string `json:"mode"` // none, SSE-S3, SSE-KMS or SSE-C
+	KMSKeyID       string `json:"kms_key_id,omitempty"`
+	CustomerKeyMD5 string `json:"customer_key_md5,omitempty"`
+}
+
+type ObjectStatRequest struct {
+	Bucket         string `json:"bucket"`
+	Key            string `json:"key"`
+	VersionID      string `json:"version_id,omitempty"`
+	SSECustomerKey string `json:"sse_customer_key,omitempty"` // required for SSE-C objects
+}
+
+type ObjectStat struct {
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	ContentType  string            `json:"content_type"`
+	ETag         string            `json:"etag"`
+	LastModified time.Time         `json:"last_modified"`
+	VersionID    string            `json:"version_id,omitempty"`
+	StorageClass string            `json:"storage_class,omitempty"`
+	Encryption   ObjectEncryption  `json:"encryption"`
+	Lock         *ObjectLockStatus `json:"lock,omitempty"`
+}
+
+// StatObject returns object metadata including its encryption status.
+func (app *App) StatObject(c *gin.Context) {
+	var req ObjectStatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Key == "" {
+		c.JSON(400, gin.H{"error": "key is required"})
+		return
+	}
+
+	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
+	if bucketConfig == nil {
+		return
+	}
+
+	sse, err := customerKeyEncryption(*bucketConfig, req.SSECustomerKey)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	mio, err := Connect(*bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	info, err := mio.StatObject(context.Background(), bucketConfig.BucketName, req.Key, minio.StatObjectOptions{
+		ServerSideEncryption: sse,
+		VersionID:            req.VersionID,
+	})
+	if err != nil {
+		// SSE-C objects can't be read, not even their metadata, without the key.
+		if minio.ToErrorResponse(err).StatusCode == http.StatusBadRequest && sse == nil {
+			c.JSON(400, gin.H{"error": "object may be encrypted with SSE-C; provide sse_customer_key"})
+			return
+		}
+		slog.Error(err.Error())
+		c.JSON(404, gin.H{"error": "object not found"})
+		return
+	}
+
+	res := ObjectStat{
+		Key:          info.Key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+		VersionID:    info.VersionID,
+		StorageClass: info.StorageClass,
+		Encryption:   encryptionFromInfo(info),
+	}
+	if lock := lockStatusFromInfo(info); lock.Mode != "" || lock.LegalHold {
+		res.Lock = &lock
+	}
+
+	c.JSON(200, res)
+}
+
+// validateEncryptionConfig checks a connection's default encryption.
+func validateEncryptionConfig(cfg *EncryptionConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	switch cfg.Mode {
+	case "", EncryptionNone, EncryptionSSES3:
+		return nil
+	case EncryptionSSEKMS:
+		if strings.TrimSpace(cfg.KMSKeyID) == "" {
+			return errors.New("encryption: kms_key_id is required for SSE-KMS")
+		}
+		return nil
+	case EncryptionSSEC:
+		return errors.New("encryption: SSE-C can't be a connection default; pass the key per upload")
+	default:
+		return errors.New("encryption: mode must be none, SSE-S3 or SSE-KMS")
+	}
+}
+
+// uploadEncryption resolves the encryption for a new object: the override if
+// given, otherwise the connection default. nil means no SSE headers.
+func uploadEncryption(cfg BucketConfig, o *EncryptionOverride) (encrypt.ServerSide, error) {
+	mode, keyID, kmsContext := EncryptionNone, "", map[string]string(nil)
+	if cfg.Encryption != nil && cfg.Encryption.Mode != "" {
+		mode, keyID, kmsContext = cfg.Encryption.Mode, cfg.Encryption.KMSKeyID, cfg.Encryption.KMSContext
+	}
+	if o != nil && o.Mode != "" {
+		mode, keyID, kmsContext = o.Mode, o.KMSKeyID, o.KMSContext
+	}
+
+	switch mode {
+	case EncryptionNone:
+		return nil, nil
+	case EncryptionSSES3:
+		return encrypt.NewSSE(), nil
+	case EncryptionSSEKMS:
+		if strings.TrimSpace(keyID) == "" {
+			return nil, httpError{status: 400, msg: "kms_key_id is required for SSE-KMS"}
+		}
+		var ctx interface{}
+		if len(kmsContext) > 0 {
+			ctx = kmsContext
+		}
+		sse, err := encrypt.NewSSEKMS(keyID, ctx)
+		if err != nil {
+			return nil, httpError{status: 400, msg: err.Error()}
+		}
+		return sse, nil
+	case EncryptionSSEC:
+		if o == nil || o.CustomerKey == "" {
+			return nil, httpError{status: 400, msg: "customer_key is required for SSE-C"}
+		}
+		return customerKeyEncryption(cfg, o.CustomerKey)
+	default:
+		return nil, httpError{status: 400, msg: "encryption mode must be none, SSE-S3, SSE-KMS or SSE-C"}
+	}
+}
+
+// customerKeyEncryption parses a base64 SSE-C key. An empty key means the
+// object isn't SSE-C encrypted and returns nil.
+func customerKeyEncryption(cfg BucketConfig, b64 string) (encrypt.ServerSide, error) {
+	b64 = strings.TrimSpace(b64)
+	if b64 == "" {
+		return nil, nil
+	}
+	if !cfg.Secure {
+		return nil, httpError{status: 400, msg: "SSE-C requires a secure (https) connection"}
+	}
+
+	key, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, httpError{status: 400, msg: "sse customer key must be base64"}
+	}
+	sse, err := encrypt.NewSSEC(key)
+	if err != nil {
+		return nil, httpError{status: 400, msg: "sse customer key must be 32 bytes"}
+	}
+	return sse, nil
+}
+
+// sseHeaders returns the headers an encryption adds to a request. The UI
+// must send them with presigned SSE-C part uploads.
+func sseHeaders(sse encrypt.ServerSide) http.Header {
+	h := http.Header{}
+	if sse != nil {
+		sse.Marshal(h)
+	}
+	return h
+}
+
+func encryptionMode(sse encrypt.ServerSide) string {
+	if sse == nil {
+		return EncryptionNone
+	}
+	switch sse.Type() {
+	case encrypt.S3:
+		return EncryptionSSES3
+	case encrypt.KMS:
+		return EncryptionSSEKMS
+	case encrypt.SSEC:
+		return EncryptionSSEC
+	}
+	return EncryptionNone
+}
+
+func encryptionFromInfo(info minio.ObjectInfo) ObjectEncryption {
+	if info.Metadata.Get(encrypt.SseCustomerAlgorithm) != "" {
+		return ObjectEncryption{Mode: EncryptionSSEC, CustomerKeyMD5: info.Metadata.Get(encrypt.SseCustomerKeyMD5)}
+	}
+	switch strings.ToLower(info.Metadata.Get(encrypt.SseGenericHeader)) {
+	case "aes256":
+		return ObjectEncryption{Mode: EncryptionSSES3}
+	case "aws:kms", "aws:kms:dsse":
+		return ObjectEncryption{Mode: EncryptionSSEKMS, KMSKeyID: info.Metadata.Get(encrypt.SseKmsKeyID)}
+	}
+	return ObjectEncryption{Mode: EncryptionNone}
+}
@@ -0,0 +1,472 @@
+package buckets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"b0k3ts/internal/pkg/audit"
+	"b0k3ts/internal/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/notification"
+)
+
+// Event names published for changes made through b0k3ts. They follow the S3
+// notification names so the UI handles both sources the same way.
+const (
+	EventObjectCreated = string(notification.ObjectCreatedCompleteMultipartUpload)
+	EventObjectCopied  = string(notification.ObjectCreatedCopy)
+	EventObjectRemoved = string(notification.ObjectRemovedDelete)
+	EventPrefixMoved   = "b0k3ts:PrefixMoved"
+
+	EventSourceApp = "b0k3ts" // published by this server
+	EventSourceS3  = "s3"     // received from the endpoint (MinIO listen API)
+
+	// Per-subscriber buffer; slow clients drop events rather than block uploads.
+	eventSubscriberBuffer = 256
+	eventKeepAlive        = 25 * time.Second
+)
+
+// ObjectEvent is one entry in the live event feed.
+type ObjectEvent struct {
+	Bucket    string    `json:"bucket"` // bucket connection id
+	EventName string    `json:"event_name"`
+	Key       string    `json:"key"`
+	DestKey   string    `json:"dest_key,omitempty"` // moves only
+	Size      int64     `json:"size,omitempty"`
+	ETag      string    `json:"etag,omitempty"`
+	VersionID string    `json:"version_id,omitempty"`
+	User      string    `json:"user,omitempty"`
+	Source    string    `json:"source"`
+	Time      time.Time `json:"time"`
+}
+
+// BucketNotificationTarget is one queue/topic/lambda entry of a bucket
+// notification configuration.
+type BucketNotificationTarget struct {
+	Type   string   `json:"type"` // queue, topic or lambda
+	ID     string   `json:"id,omitempty"`
+	ARN    string   `json:"arn"`
+	Events []string `json:"events"` // e.g. s3:ObjectCreated:*
+	Prefix string   `json:"prefix,omitempty"`
+	Suffix string   `json:"suffix,omitempty"`
+}
+
+type BucketNotificationRequest struct {
+	Bucket  string                     `json:"bucket"`
+	Targets []BucketNotificationTarget `json:"targets"`
+}
+
+// eventHub fans b0k3ts-originated events out to SSE subscribers.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[*eventSubscriber]struct{}
+
+	closeOnce sync.Once
+	closed    chan struct{} // closed at shutdown
+
+	relay atomic.Pointer[eventRelay] // set with ha.enabled
+}
+
+type eventSubscriber struct {
+	buckets map[string]bool
+	prefix  string
+	ch      chan ObjectEvent
+}
+
+var objectEvents = &eventHub{subs: map[*eventSubscriber]struct{}{}, closed: make(chan struct{})}
+
+// CloseEventStreams ends every open event stream, so shutdown isn't held up
+// by clients that never disconnect. EventSource clients reconnect, reaching
+// another replica.
+func CloseEventStreams() {
+	objectEvents.closeOnce.Do(func() { close(objectEvents.closed) })
+}
+
+func (h *eventHub) subscribe(buckets []string, prefix string) *eventSubscriber {
+	s := &eventSubscriber{
+		buckets: make(map[string]bool, len(buckets)),
+		prefix:  prefix,
+		ch:      make(chan ObjectEvent, eventSubscriberBuffer),
+	}
+	for _, b := range buckets {
+		s.buckets[b] = true
+	}
+
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+func (h *eventHub) unsubscribe(s *eventSubscriber) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+}
+
+// publish delivers an event published on this replica, and relays it to
+// the others.
+func (h *eventHub) publish(e ObjectEvent) {
+	h.deliver(e)
+	if r := h.relay.Load(); r != nil {
+		r.put(e)
+	}
+}
+
+func (h *eventHub) deliver(e ObjectEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subs {
+		if !s.buckets[e.Bucket] || !(strings.HasPrefix(e.Key, s.prefix) || (e.DestKey != "" && strings.HasPrefix(e.DestKey, s.prefix))) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			slog.Warn("dropping object event for slow subscriber", "bucket", e.Bucket, "key", e.Key)
+		}
+	}
+}
+
+// publishObjectEvent records a change made through b0k3ts on the live feed.
+func publishObjectEvent(bucket, eventName, key, user string, size int64) {
+	objectEvents.publish(ObjectEvent{
+		Bucket:    bucket,
+		EventName: eventName,
+		Key:       key,
+		Size:      size,
+		User:      user,
+		Source:    EventSourceApp,
+		Time:      time.Now().UTC(),
+	})
+}
+
+// publishMoveEvent records a move. Single-object moves show up as a copy to
+// the new key plus a delete of the old one; prefix moves as one event.
+func publishMoveEvent(c *gin.Context, bucket, eventName, from, to string) {
+	userInfo, _ := auth.TokenToUserData(c.GetHeader("Authorization"))
+	now := time.Now().UTC()
+
+	objectEvents.publish(ObjectEvent{Bucket: bucket, EventName: eventName, Key: from, DestKey: to, User: userInfo.Email, Source: EventSourceApp, Time: now})
+	if eventName == EventObjectCopied {
+		objectEvents.publish(ObjectEvent{Bucket: bucket, EventName: EventObjectRemoved, Key: from, User: userInfo.Email, Source: EventSourceApp, Time: now})
+	}
+}
+
+// ObjectEvents streams object events as Server-Sent Events.
+//
+// Query: bucket (repeatable; default all authorized connections), prefix.
+// Browsers' EventSource can't set headers, so the token may also be passed as
+// access_token.
+//
+// Changes made through b0k3ts are always streamed. For MinIO endpoints the
+// ListenBucketNotification API adds changes made by any other client; other
+// endpoints fall back to b0k3ts-originated events only.
+func (app *App) ObjectEvents(c *gin.Context) {
+	header := c.GetHeader("Authorization")
+	if header == "" && c.Query("access_token") != "" {
+		header = "Bearer " + c.Query("access_token")
+	}
+	userInfo, _ := auth.TokenToUserData(header)
+	if strings.TrimSpace(userInfo.Email) == "" {
+		c.JSON(401, gin.H{"error": "missing user"})
+		return
+	}
+
+	cfgs, ok := listBucketConfigsOrRespond(c, app.DB)
+	if !ok {
+		return
+	}
+	cfgs = filterAuthorizedBucketConfigs(*app, userInfo, cfgs)
+
+	if requested := c.QueryArray("bucket"); len(requested) > 0 {
+		selected := make([]BucketConfig, 0, len(requested))
+		for _, name := range requested {
+			i := slices.IndexFunc(cfgs, func(cfg BucketConfig) bool { return cfg.BucketName == name })
+			if i < 0 {
+				c.JSON(400, gin.H{"error": "Unauthorized"})
+				return
+			}
+			selected = append(selected, cfgs[i])
+		}
+		cfgs = selected
+	}
+
+	names := make([]string, 0, len(cfgs))
+	for i := range cfgs {
+		cfgs[i].callerToken = bearerToken(header)
+		cfgs[i].oidcProviderURL = app.OIDCConfig.ProviderUrl
+		names = append(names, cfgs[i].BucketName)
+	}
+	prefix := c.Query("prefix")
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	sub := objectEvents.subscribe(names, prefix)
+	defer objectEvents.unsubscribe(sub)
+
+	// Buckets whose endpoint answers the MinIO listen API. Their local events
+	// are skipped because the endpoint reports the same change.
+	var liveMu sync.Mutex
+	live := map[string]bool{}
+	status := make(chan gin.H, len(cfgs))
+	remote := make(chan ObjectEvent, eventSubscriberBuffer)
+
+	for _, cfg := range cfgs {
+		liveMu.Lock()
+		live[cfg.BucketName] = true
+		liveMu.Unlock()
+
+		go func(cfg BucketConfig) {
+			err := listenBucket(ctx, cfg, prefix, remote)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				err = errors.New("listen stream ended")
+			}
+			liveMu.Lock()
+			live[cfg.BucketName] = false
+			liveMu.Unlock()
+			status <- gin.H{"bucket": cfg.BucketName, "live": false, "error": err.Error()}
+		}(cfg)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.SSEvent("ready", gin.H{"buckets": names, "prefix": prefix})
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-objectEvents.closed:
+			c.SSEvent("shutdown", gin.H{"reconnect": true})
+			return false
+		case e := <-sub.ch:
+			liveMu.Lock()
+			skip := live[e.Bucket]
+			liveMu.Unlock()
+			if !skip {
+				c.SSEvent("object", e)
+			}
+		case e := <-remote:
+			c.SSEvent("object", e)
+		case s := <-status:
+			c.SSEvent("status", s)
+		case <-keepAlive.C:
+			c.SSEvent("ping", time.Now().UTC())
+		}
+		return true
+	})
+}
+
+// listenBucket forwards MinIO bucket notifications until ctx ends. It returns
+// an error when the endpoint doesn't support the listen API.
+func listenBucket(ctx context.Context, cfg BucketConfig, prefix string, out chan<- ObjectEvent) error {
+	mio, err := Connect(cfg)
+	if err != nil {
+		return err
+	}
+
+	events := []string{string(notification.ObjectCreatedAll), string(notification.ObjectRemovedAll)}
+	for info := range mio.ListenBucketNotification(ctx, cfg.BucketName, prefix, "", events) {
+		if info.Err != nil {
+			return info.Err
+		}
+		for _, r := range info.Records {
+			e := ObjectEvent{
+				Bucket:    cfg.BucketName,
+				EventName: r.EventName,
+				Key:       r.S3.Object.Key,
+				Size:      r.S3.Object.Size,
+				ETag:      r.S3.Object.ETag,
+				VersionID: r.S3.Object.VersionID,
+				User:      r.UserIdentity.PrincipalID,
+				Source:    EventSourceS3,
+				Time:      time.Now().UTC(),
+			}
+			if t, err := time.Parse(time.RFC3339Nano, r.EventTime); err == nil {
+				e.Time = t
+			}
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// GetBucketNotifications returns the bucket notification configuration.
+func (app *App) GetBucketNotifications(c *gin.Context) {
+	var req BucketNotificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
+	if bucketConfig == nil {
+		return
+	}
+
+	mio, err := Connect(*bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	cfg, err := mio.GetBucketNotification(context.Background(), bucketConfig.BucketName)
+	if err != nil {
+		respondNotificationError(c, err)
+		return
+	}
+
+	targets := make([]BucketNotificationTarget, 0)
+	for _, q := range cfg.QueueConfigs {
+		targets = append(targets, notificationTargetFromMinio("queue", q.Queue, q.Config))
+	}
+	for _, t := range cfg.TopicConfigs {
+		targets = append(targets, notificationTargetFromMinio("topic", t.Topic, t.Config))
+	}
+	for _, l := range cfg.LambdaConfigs {
+		targets = append(targets, notificationTargetFromMinio("lambda", l.Lambda, l.Config))
+	}
+
+	c.JSON(200, BucketNotificationRequest{Bucket: req.Bucket, Targets: targets})
+}
+
+// SetBucketNotifications replaces the bucket notification configuration. An
+// empty target list removes it. Admin only; changes are audited.
+func (app *App) SetBucketNotifications(c *gin.Context) {
+	userInfo, ok := auth.VerifiedAdminOrRespond(c)
+	if !ok {
+		return
+	}
+
+	var req BucketNotificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
+	if bucketConfig == nil {
+		return
+	}
+
+	cfg := notification.Configuration{}
+	for i, t := range req.Targets {
+		arn, err := notification.NewArnFromString(t.ARN)
+		if err != nil {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("target %d: invalid arn %q", i, t.ARN)})
+			return
+		}
+		if len(t.Events) == 0 {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("target %d: events is required", i)})
+			return
+		}
+
+		nc := notification.NewConfig(arn)
+		nc.ID = t.ID
+		for _, e := range t.Events {
+			nc.AddEvents(notification.EventType(e))
+		}
+		if t.Prefix != "" {
+			nc.AddFilterPrefix(t.Prefix)
+		}
+		if t.Suffix != "" {
+			nc.AddFilterSuffix(t.Suffix)
+		}
+
+		added := false
+		switch t.Type {
+		case "queue":
+			added = cfg.AddQueue(nc)
+		case "topic":
+			added = cfg.AddTopic(nc)
+		case "lambda":
+			added = cfg.AddLambda(nc)
+		default:
+			c.JSON(400, gin.H{"error": fmt.Sprintf("target %d: type must be queue, topic or lambda", i)})
+			return
+		}
+		if !added {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("target %d: overlaps an earlier target", i)})
+			return
+		}
+	}
+
+	mio, err := Connect(*bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := mio.SetBucketNotification(context.Background(), bucketConfig.BucketName, cfg); err != nil {
+		respondNotificationError(c, err)
+		return
+	}
+
+	if err := audit.Record(app.DB, userInfo.Email, "bucket_notifications_set", req.Bucket, map[string]string{
+		"targets": fmt.Sprint(len(req.Targets)),
+	}); err != nil {
+		slog.Error("failed to record bucket notification audit event", "err", err)
+	}
+
+	c.JSON(200, gin.H{"message": "Bucket notifications updated"})
+}
+
+func notificationTargetFromMinio(kind, arn string, cfg notification.Config) BucketNotificationTarget {
+	t := BucketNotificationTarget{Type: kind, ID: cfg.ID, ARN: arn, Events: make([]string, 0, len(cfg.Events))}
+	if t.ARN == "" {
+		t.ARN = cfg.Arn.String()
+	}
+	for _, e := range cfg.Events {
+		t.Events = append(t.Events, string(e))
+	}
+	if cfg.Filter != nil {
+		for _, r := range cfg.Filter.S3Key.FilterRules {
+			switch strings.ToLower(r.Name) {
+			case "prefix":
+				t.Prefix = r.Value
+			case "suffix":
+				t.Suffix = r.Value
+			}
+		}
+	}
+	return t
+}
+
+func respondNotificationError(c *gin.Context, err error) {
+	if minio.ToErrorResponse(err).Code == "NotImplemented" {
+		c.JSON(501, gin.H{"error": "bucket notifications are not supported by this endpoint"})
+		return
+	}
+	slog.Error("bucket notification request failed", "err", err)
+	c.JSON(400, gin.H{"error": err.Error()})
+}
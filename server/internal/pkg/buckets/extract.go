@@ -0,0 +1,600 @@
+package buckets
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"b0k3ts/internal/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+const ExtractJobPrefix = storage.NSExtractJobs
+
+// A running job refreshes its heartbeat; one whose heartbeat has expired
+// was left behind by a replica that stopped, and is reported as failed.
+const (
+	extractHeartbeatInterval = 30 * time.Second
+	extractHeartbeatTTL      = 2 * time.Minute
+)
+
+// Guardrails for archive extraction. These protect the server and the bucket
+// from zip bombs and oversized archives; raise them if you really need to.
+const (
+	extractMaxArchiveBytes = 10 << 30 // 10 GiB archive object
+	extractMaxEntries      = 10000
+	extractMaxEntryBytes   = 5 << 30  // 5 GiB per entry
+	extractMaxTotalBytes   = 20 << 30 // 20 GiB uncompressed in total
+	extractMaxRatio        = 100      // uncompressed/compressed per zip entry
+
+	// The most compressed bytes an empty zip entry needs; an empty deflate
+	// stream takes a few bytes. A header claiming 0 bytes over more data
+	// than this is lying about its size.
+	extractEmptyEntryMaxCompressed = 16
+)
+
+const (
+	ExtractStatusRunning   = "running"
+	ExtractStatusCompleted = "completed"
+	ExtractStatusFailed    = "failed"
+
+	EntryStatusExtracted = "extracted"
+	EntryStatusSkipped   = "skipped"
+	EntryStatusFailed    = "failed"
+)
+
+type ExtractRequest struct {
+	Bucket    string `json:"bucket"`              // bucket connection id
+	Key       string `json:"key"`                 // archive object key (already uploaded)
+	Prefix    string `json:"prefix"`              // destination "folder"; empty = bucket root
+	Format    string `json:"format,omitempty"`    // optional: "zip", "tar", "tar.gz"; detected from key when empty
+	Overwrite bool   `json:"overwrite,omitempty"` // optional; default false
+}
+
+type ExtractEntryResult struct {
+	Name   string `json:"name"`          // entry name inside the archive
+	Key    string `json:"key,omitempty"` // destination object key
+	Size   int64  `json:"size"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ExtractJob struct {
+	JobId      string               `json:"job_id"`
+	Bucket     string               `json:"bucket"`
+	Key        string               `json:"key"`
+	Prefix     string               `json:"prefix"`
+	Format     string               `json:"format"`
+	CreatedBy  string               `json:"created_by"`
+	Status     string               `json:"status"`
+	Error      string               `json:"error,omitempty"`
+	Extracted  int                  `json:"extracted"`
+	Skipped    int                  `json:"skipped"`
+	Failed     int                  `json:"failed"`
+	TotalBytes int64                `json:"total_bytes"`
+	Entries    []ExtractEntryResult `json:"entries"`
+	StartedAt  time.Time            `json:"started_at"`
+	FinishedAt *time.Time           `json:"finished_at,omitempty"`
+}
+
+// ExtractArchive starts a job that unpacks an archive object into a prefix of
+// the same bucket. The job runs in the background; poll ExtractStatus for the
+// per-entry results.
+func (app *App) ExtractArchive(c *gin.Context) {
+	var req ExtractRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Error("extract failed. failed to bind json", "err", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Bucket == "" || req.Key == "" {
+		c.JSON(400, gin.H{"error": "bucket and key are required"})
+		return
+	}
+
+	format, err := archiveFormat(req.Format, req.Key)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	userInfo, ok := tokenUserOrRespond(c)
+	if !ok {
+		return
+	}
+
+	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
+	if bucketConfig == nil {
+		return
+	}
+	// The job outlives the request, and with it the caller's token.
+	if bucketConfig.PerUser() {
+		respondError(c, errPerUser("archive extraction"))
+		return
+	}
+
+	mio, err := Connect(*bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+
+	st, err := mio.StatObject(ctx, bucketConfig.BucketName, req.Key, minio.StatObjectOptions{})
+	if err != nil {
+		slog.Error("failed to stat archive", "err", err)
+		c.JSON(404, gin.H{"error": "archive object not found"})
+		return
+	}
+	if st.Size > extractMaxArchiveBytes {
+		c.JSON(413, gin.H{"error": "archive too large"})
+		return
+	}
+
+	sse, err := uploadEncryption(*bucketConfig, nil)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	jobID, err := newJobID()
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	job := &ExtractJob{
+		JobId:     jobID,
+		Bucket:    req.Bucket,
+		Key:       req.Key,
+		Prefix:    normalizePrefix(strings.TrimPrefix(req.Prefix, "/")),
+		Format:    format,
+		CreatedBy: userInfo.Email,
+		Status:    ExtractStatusRunning,
+		Entries:   make([]ExtractEntryResult, 0),
+		StartedAt: time.Now().UTC(),
+	}
+
+	if err := app.saveExtractJob(job); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	app.extractHeartbeat(job.JobId)
+
+	c.JSON(202, job)
+
+	app.jobs.Go(func(ctx context.Context) {
+		app.runExtractJob(ctx, mio, bucketConfig.BucketName, sse, st.Size, req.Overwrite, job)
+	})
+}
+
+// StopJobs waits for running extraction jobs until ctx is done, then
+// cancels the rest; they record themselves as failed before returning.
+// New jobs are cancelled as soon as they start.
+func (app *App) StopJobs(ctx context.Context) {
+	app.jobs.stop(ctx)
+}
+
+// jobTracker runs background jobs that outlive their request, so shutdown
+// can wait for them.
+type jobTracker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newJobTracker() *jobTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobTracker{ctx: ctx, cancel: cancel}
+}
+
+func (t *jobTracker) Go(fn func(ctx context.Context)) {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		fn(t.ctx)
+	}()
+}
+
+func (t *jobTracker) stop(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+		slog.Warn("cancelling extract jobs still running at shutdown")
+	}
+	t.cancel()
+	<-done
+}
+
+// ExtractStatus returns an extraction job, including per-entry results.
+func (app *App) ExtractStatus(c *gin.Context) {
+	job, err := app.loadExtractJob(c.Param("job_id"))
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(404, gin.H{"error": "extract job not found"})
+		return
+	}
+
+	if authorizeAndExtract(*app, c, job.Bucket) == nil {
+		return
+	}
+
+	if job.Status == ExtractStatusRunning {
+		app.failAbandonedExtractJob(job)
+	}
+
+	c.JSON(200, job)
+}
+
+func (app *App) extractHeartbeat(jobID string) {
+	if err := storage.PutWithTTL(app.DB, storage.NSJobHeartbeats+jobID, []byte("1"), extractHeartbeatTTL); err != nil {
+		slog.Error("failed to save extract job heartbeat", "job", jobID, "err", err)
+	}
+}
+
+// failAbandonedExtractJob marks a running job failed if its heartbeat has
+// expired, updating job in place.
+func (app *App) failAbandonedExtractJob(job *ExtractJob) {
+	err := app.DB.Update(func(tx storage.Tx) error {
+		_, err := tx.Get(storage.NSJobHeartbeats + job.JobId)
+		if !storage.IsNotFound(err) {
+			return err
+		}
+		jobs := extractJobRepo(app.DB).Tx(tx)
+		stored, err := jobs.Get(job.JobId)
+		if err != nil {
+			return err
+		}
+		*job = stored
+		if job.Status != ExtractStatusRunning {
+			return nil
+		}
+
+		now := time.Now().UTC()
+		job.Status = ExtractStatusFailed
+		job.Error = "the server running this job stopped before it finished"
+		job.FinishedAt = &now
+		return jobs.Put(job.JobId, *job)
+	})
+	if err != nil {
+		slog.Error("failed to check extract job heartbeat", "job", job.JobId, "err", err)
+	}
+}
+
+func (app *App) runExtractJob(ctx context.Context, mio *minio.Client, bucketName string, sse encrypt.ServerSide, size int64, overwrite bool, job *ExtractJob) {
+	x := &extractor{
+		ctx:        ctx,
+		mio:        mio,
+		bucketName: bucketName,
+		sse:        sse,
+		overwrite:  overwrite,
+		job:        job,
+		save:       app.saveExtractJob,
+	}
+
+	heartbeatCtx, stopHeartbeat := context.WithCancel(context.Background())
+	go func() {
+		t := time.NewTicker(extractHeartbeatInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-heartbeatCtx.Done():
+				return
+			case <-t.C:
+				app.extractHeartbeat(job.JobId)
+			}
+		}
+	}()
+
+	err := x.run(size)
+	stopHeartbeat()
+
+	now := time.Now().UTC()
+	job.FinishedAt = &now
+	job.Status = ExtractStatusCompleted
+	if err != nil {
+		slog.Error("extract job failed", "job", job.JobId, "err", err)
+		job.Status = ExtractStatusFailed
+		job.Error = err.Error()
+	}
+
+	if err := app.saveExtractJob(job); err != nil {
+		slog.Error("failed to save extract job", "job", job.JobId, "err", err)
+	}
+}
+
+type extractor struct {
+	ctx        context.Context
+	mio        *minio.Client
+	bucketName string
+	sse        encrypt.ServerSide // connection default for extracted objects
+	overwrite  bool
+	job        *ExtractJob
+	save       func(*ExtractJob) error
+}
+
+func (x *extractor) run(size int64) error {
+	obj, err := x.mio.GetObject(x.ctx, x.bucketName, x.job.Key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	switch x.job.Format {
+	case "zip":
+		return x.zip(obj, size)
+	case "tar.gz":
+		gz, err := gzip.NewReader(obj)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return x.tar(gz)
+	default:
+		return x.tar(obj)
+	}
+}
+
+func (x *extractor) zip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	if len(zr.File) > extractMaxEntries {
+		return fmt.Errorf("archive has %d entries (max %d)", len(zr.File), extractMaxEntries)
+	}
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			x.record(ExtractEntryResult{Name: f.Name, Status: EntryStatusSkipped, Error: "not a regular file"})
+			continue
+		}
+
+		uncompressed := int64(f.UncompressedSize64)
+		if uncompressed == 0 && f.CompressedSize64 > extractEmptyEntryMaxCompressed {
+			x.record(ExtractEntryResult{Name: f.Name, Status: EntryStatusFailed, Error: "entry header claims 0 bytes but it has compressed data"})
+			continue
+		}
+		if f.CompressedSize64 > 0 && f.UncompressedSize64/f.CompressedSize64 > extractMaxRatio {
+			return fmt.Errorf("entry %q exceeds the maximum compression ratio", f.Name)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			x.record(ExtractEntryResult{Name: f.Name, Status: EntryStatusFailed, Error: err.Error()})
+			continue
+		}
+		err = x.put(f.Name, rc, uncompressed)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) tar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	entries := 0
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entries++
+		if entries > extractMaxEntries {
+			return fmt.Errorf("archive has more than %d entries", extractMaxEntries)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir, tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeReg:
+		default:
+			x.record(ExtractEntryResult{Name: hdr.Name, Status: EntryStatusSkipped, Error: "not a regular file"})
+			continue
+		}
+
+		if err := x.put(hdr.Name, tr, hdr.Size); err != nil {
+			return err
+		}
+	}
+}
+
+// put uploads a single entry. Problems with the entry itself are recorded and
+// skipped; an error is only returned when the whole job must stop.
+func (x *extractor) put(name string, r io.Reader, size int64) error {
+	rel, err := sanitizeEntryName(name)
+	if err != nil {
+		x.record(ExtractEntryResult{Name: name, Size: size, Status: EntryStatusSkipped, Error: err.Error()})
+		return nil
+	}
+
+	key := x.job.Prefix + rel
+	res := ExtractEntryResult{Name: name, Key: key, Size: size}
+
+	if size > extractMaxEntryBytes {
+		res.Status, res.Error = EntryStatusSkipped, "entry too large"
+		x.record(res)
+		return nil
+	}
+	if x.job.TotalBytes+size > extractMaxTotalBytes {
+		return fmt.Errorf("archive expands beyond %d bytes", int64(extractMaxTotalBytes))
+	}
+
+	if err := ensureDestinationAbsent(x.ctx, x.mio, x.bucketName, key, x.overwrite); err != nil {
+		res.Status, res.Error = EntryStatusSkipped, err.Error()
+		x.record(res)
+		return nil
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = OctetStream
+	}
+
+	// The limit reader stops at the size the header declares. Reading one
+	// more byte then shows whether the header under-reported the entry, and
+	// lets the zip reader reach EOF and check the CRC.
+	_, err = x.mio.PutObject(x.ctx, x.bucketName, key, io.LimitReader(r, size), size, minio.PutObjectOptions{
+		ContentType:          contentType,
+		ServerSideEncryption: x.sse,
+	})
+	if err != nil {
+		slog.Error("failed to put extracted object", "key", key, "err", err)
+		res.Status, res.Error = EntryStatusFailed, err.Error()
+		x.record(res)
+		return nil
+	}
+	if err := checkEntryEnd(r); err != nil {
+		if rmErr := x.mio.RemoveObject(x.ctx, x.bucketName, key, minio.RemoveObjectOptions{}); rmErr != nil {
+			slog.Error("failed to remove partially extracted object", "key", key, "err", rmErr)
+		}
+		res.Status, res.Error = EntryStatusFailed, err.Error()
+		x.record(res)
+		return nil
+	}
+
+	x.job.TotalBytes += size
+	res.Status = EntryStatusExtracted
+	publishObjectEvent(x.job.Bucket, EventObjectCreated, key, x.job.CreatedBy, size)
+	x.record(res)
+	return nil
+}
+
+// checkEntryEnd makes sure an entry has nothing left after its declared size.
+// The zip reader itself reports reading past the header size as ErrFormat.
+func checkEntryEnd(r io.Reader) error {
+	n, err := io.ReadFull(r, make([]byte, 1))
+	switch {
+	case n > 0 || errors.Is(err, zip.ErrFormat):
+		return errors.New("entry larger than its header")
+	case err == io.EOF:
+		return nil
+	default:
+		return err
+	}
+}
+
+func (x *extractor) record(res ExtractEntryResult) {
+	switch res.Status {
+	case EntryStatusExtracted:
+		x.job.Extracted++
+	case EntryStatusSkipped:
+		x.job.Skipped++
+	default:
+		x.job.Failed++
+	}
+	x.job.Entries = append(x.job.Entries, res)
+
+	// Persist progress now and then so pollers see something on big archives.
+	if len(x.job.Entries)%100 == 0 && x.save != nil {
+		if err := x.save(x.job); err != nil {
+			slog.Error("failed to save extract job progress", "job", x.job.JobId, "err", err)
+		}
+	}
+}
+
+// sanitizeEntryName turns an archive entry name into a relative object key,
+// rejecting absolute paths and anything that climbs out of the destination.
+func sanitizeEntryName(name string) (string, error) {
+	n := strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(n, "/") || (len(n) > 1 && n[1] == ':') {
+		return "", errors.New("absolute path not allowed")
+	}
+	for _, part := range strings.Split(n, "/") {
+		if part == ".." {
+			return "", errors.New("path traversal not allowed")
+		}
+	}
+
+	n = path.Clean(n)
+	if n == "." || n == "" {
+		return "", errors.New("empty entry name")
+	}
+	return n, nil
+}
+
+func archiveFormat(format, key string) (string, error) {
+	f := strings.ToLower(strings.TrimSpace(format))
+	if f == "" {
+		k := strings.ToLower(key)
+		switch {
+		case strings.HasSuffix(k, ".zip"):
+			f = "zip"
+		case strings.HasSuffix(k, ".tar.gz"), strings.HasSuffix(k, ".tgz"):
+			f = "tar.gz"
+		case strings.HasSuffix(k, ".tar"):
+			f = "tar"
+		}
+	}
+
+	switch f {
+	case "zip", "tar", "tar.gz":
+		return f, nil
+	case "tgz":
+		return "tar.gz", nil
+	case "":
+		return "", errors.New("unable to detect archive format; set format to zip, tar or tar.gz")
+	default:
+		return "", fmt.Errorf("unsupported archive format %q", f)
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// extractJobRepo stores extraction jobs keyed by job id.
+func extractJobRepo(db storage.Store) storage.Repository[ExtractJob] {
+	return storage.NewRepository[ExtractJob](db, ExtractJobPrefix)
+}
+
+func (app *App) saveExtractJob(job *ExtractJob) error {
+	return extractJobRepo(app.DB).Put(job.JobId, *job)
+}
+
+func (app *App) loadExtractJob(jobID string) (*ExtractJob, error) {
+	job, err := extractJobRepo(app.DB).Get(jobID)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
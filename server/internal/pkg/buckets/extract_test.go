@@ -0,0 +1,52 @@
+package buckets
+
+import "testing"
+
+func TestSanitizeEntryName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "file.txt", want: "file.txt"},
+		{name: "dir/sub/file.txt", want: "dir/sub/file.txt"},
+		{name: "./dir//file.txt", want: "dir/file.txt"},
+		{name: "dir/", want: "dir"},
+		{name: `dir\sub\file.txt`, want: "dir/sub/file.txt"},
+		{name: "a..b/file.txt", want: "a..b/file.txt"},
+
+		{name: "..", wantErr: true},
+		{name: "../file.txt", wantErr: true},
+		{name: "dir/../../file.txt", wantErr: true},
+		{name: "dir/..", wantErr: true},
+		{name: `..\file.txt`, wantErr: true},
+		{name: `dir\..\..\file.txt`, wantErr: true},
+
+		{name: "/etc/passwd", wantErr: true},
+		{name: `\windows\system32`, wantErr: true},
+		{name: "C:/windows/system32", wantErr: true},
+		{name: `C:\windows\system32`, wantErr: true},
+		{name: "c:file.txt", wantErr: true},
+
+		{name: "", wantErr: true},
+		{name: ".", wantErr: true},
+		{name: "./", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := sanitizeEntryName(tt.name)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("sanitizeEntryName(%q) = %q, want error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("sanitizeEntryName(%q) error: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("sanitizeEntryName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package buckets
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// How many connections a readiness check probes at once.
+const connectionCheckWorkers = 8
+
+// ErrCheckSkipped marks a connection that can't be checked without a
+// caller, such as web_identity connections.
+var ErrCheckSkipped = errors.New("needs a caller's token")
+
+// ConnectionCheck is the result of probing one connection's bucket.
+type ConnectionCheck struct {
+	Bucket  string
+	Latency time.Duration
+	Err     error // nil, ErrCheckSkipped or the probe's error
+}
+
+// CheckConnections asks each connection's endpoint whether its bucket
+// exists, a few at a time, until ctx is done.
+func (app *App) CheckConnections(ctx context.Context) ([]ConnectionCheck, error) {
+	cfgs, err := connectionRepo(app.DB).List()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]ConnectionCheck, len(cfgs))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, connectionCheckWorkers)
+
+	for i, cfg := range cfgs {
+		out[i].Bucket = cfg.BucketName
+		if cfg.PerUser() {
+			out[i].Err = ErrCheckSkipped
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(res *ConnectionCheck, cfg BucketConfig) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			start := time.Now()
+			res.Err = checkConnection(ctx, cfg)
+			res.Latency = time.Since(start)
+		}(&out[i], cfg)
+	}
+	wg.Wait()
+
+	return out, nil
+}
+
+func checkConnection(ctx context.Context, cfg BucketConfig) error {
+	mio, err := Connect(cfg)
+	if err != nil {
+		return err
+	}
+	ok, err := mio.BucketExists(ctx, cfg.BucketName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("bucket does not exist")
+	}
+	return nil
+}
@@ -0,0 +1,567 @@
+package buckets
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"b0k3ts/internal/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+const (
+	lifecycleMaxRules          = 1000
+	lifecycleDefaultSampleSize = 1000
+	lifecycleMaxSampleSize     = 10000
+)
+
+// LifecycleRule is the JSON shape the UI edits. It covers the common S3
+// lifecycle actions and is converted to/from minio's lifecycle.Rule.
+type LifecycleRule struct {
+	ID      string            `json:"id"`
+	Enabled bool              `json:"enabled"`
+	Prefix  string            `json:"prefix,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
+
+	ExpirationDays      int        `json:"expiration_days,omitempty"`
+	ExpirationDate      *time.Time `json:"expiration_date,omitempty"` // midnight UTC
+	ExpireDeleteMarkers bool       `json:"expire_delete_markers,omitempty"`
+
+	TransitionDays         int        `json:"transition_days,omitempty"`
+	TransitionDate         *time.Time `json:"transition_date,omitempty"` // midnight UTC
+	TransitionStorageClass string     `json:"transition_storage_class,omitempty"`
+
+	NoncurrentExpirationDays         int    `json:"noncurrent_expiration_days,omitempty"`
+	NoncurrentTransitionDays         int    `json:"noncurrent_transition_days,omitempty"`
+	NoncurrentTransitionStorageClass string `json:"noncurrent_transition_storage_class,omitempty"`
+
+	AbortIncompleteMultipartDays int `json:"abort_incomplete_multipart_days,omitempty"`
+
+	// Preserved names settings of the stored rule this model can't show,
+	// such as object size filters. Saving a rule with the same ID keeps them.
+	Preserved []string `json:"preserved,omitempty"`
+}
+
+type LifecycleRequest struct {
+	Bucket     string          `json:"bucket"`
+	Rules      []LifecycleRule `json:"rules"`
+	SampleSize int             `json:"sample_size,omitempty"` // preview only; default 1000, max 10000
+}
+
+type LifecycleResponse struct {
+	Bucket string          `json:"bucket"`
+	Rules  []LifecycleRule `json:"rules"`
+}
+
+type LifecycleValidationResponse struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors"`
+}
+
+// LifecycleRulePreview is an estimate from listing up to SampleSize objects
+// under the rule's prefix. Counts are lower bounds when Truncated is set.
+type LifecycleRulePreview struct {
+	ID              string   `json:"id"`
+	Prefix          string   `json:"prefix"`
+	Enabled         bool     `json:"enabled"`
+	Matched         int      `json:"matched"`
+	MatchedBytes    int64    `json:"matched_bytes"`
+	ExpiringNow     int      `json:"expiring_now"`      // already older than the expiration
+	TransitionNow   int      `json:"transitioning_now"` // already older than the transition
+	Truncated       bool     `json:"truncated"`
+	TagsNotSampled  bool     `json:"tags_not_sampled,omitempty"` // tag filters can't be checked from a listing
+	OverlapsRuleIDs []string `json:"overlaps_rule_ids,omitempty"`
+}
+
+// GetLifecycle returns the bucket lifecycle configuration as typed rules.
+func (app *App) GetLifecycle(c *gin.Context) {
+	req, bucketConfig, ok := app.lifecycleRequestOrRespond(c)
+	if !ok {
+		return
+	}
+
+	mio, err := Connect(*bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	cfg, err := bucketLifecycle(context.Background(), mio, bucketConfig.BucketName)
+	if err != nil {
+		slog.Error("failed to get bucket lifecycle", "err", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	rules := make([]LifecycleRule, 0, len(cfg.Rules))
+	for _, r := range cfg.Rules {
+		rules = append(rules, lifecycleRuleFromMinio(r))
+	}
+
+	c.JSON(200, LifecycleResponse{Bucket: req.Bucket, Rules: rules})
+}
+
+func (app *App) ValidateLifecycle(c *gin.Context) {
+	req, _, ok := app.lifecycleRequestOrRespond(c)
+	if !ok {
+		return
+	}
+
+	errs := validateLifecycleRules(req.Rules)
+	c.JSON(200, LifecycleValidationResponse{Valid: len(errs) == 0, Errors: errs})
+}
+
+// SetLifecycle replaces the whole lifecycle configuration. An empty rule list
+// removes it.
+func (app *App) SetLifecycle(c *gin.Context) {
+	req, bucketConfig, ok := app.lifecycleRequestOrRespond(c)
+	if !ok {
+		return
+	}
+
+	if errs := validateLifecycleRules(req.Rules); len(errs) > 0 {
+		c.JSON(400, LifecycleValidationResponse{Valid: false, Errors: errs})
+		return
+	}
+
+	mio, err := Connect(*bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+
+	// Rules are replaced wholesale, so carry over what the model can't show.
+	current, err := bucketLifecycle(ctx, mio, bucketConfig.BucketName)
+	if err != nil {
+		slog.Error("failed to get bucket lifecycle", "err", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	stored := make(map[string]lifecycle.Rule, len(current.Rules))
+	for _, r := range current.Rules {
+		stored[r.ID] = r
+	}
+
+	cfg := lifecycle.NewConfiguration()
+	for _, r := range req.Rules {
+		out := lifecycleRuleToMinio(r)
+		if prev, ok := stored[r.ID]; ok && r.ID != "" {
+			keepUnmodeledLifecycleFields(&out, prev)
+		}
+		cfg.Rules = append(cfg.Rules, out)
+	}
+
+	if err := mio.SetBucketLifecycle(ctx, bucketConfig.BucketName, cfg); err != nil {
+		slog.Error("failed to set bucket lifecycle", "err", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	slog.Info("bucket lifecycle updated", "bucket", req.Bucket, "rules", len(req.Rules))
+	c.JSON(200, gin.H{"message": "Lifecycle configuration updated"})
+}
+
+// PreviewLifecycle estimates, per rule, how many objects it would touch.
+func (app *App) PreviewLifecycle(c *gin.Context) {
+	req, bucketConfig, ok := app.lifecycleRequestOrRespond(c)
+	if !ok {
+		return
+	}
+
+	if errs := validateLifecycleRules(req.Rules); len(errs) > 0 {
+		c.JSON(400, LifecycleValidationResponse{Valid: false, Errors: errs})
+		return
+	}
+
+	mio, err := Connect(*bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	sample := clampInt(req.SampleSize, lifecycleDefaultSampleSize, lifecycleMaxSampleSize)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	previews := make([]LifecycleRulePreview, 0, len(req.Rules))
+	for _, r := range req.Rules {
+		p, err := previewLifecycleRule(ctx, mio, bucketConfig.BucketName, r, sample, now)
+		if err != nil {
+			slog.Error("failed to preview lifecycle rule", "rule", r.ID, "err", err)
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		p.OverlapsRuleIDs = overlappingRules(r, req.Rules)
+		previews = append(previews, p)
+	}
+
+	c.JSON(200, gin.H{"bucket": req.Bucket, "sample_size": sample, "rules": previews})
+}
+
+func (app *App) lifecycleRequestOrRespond(c *gin.Context) (LifecycleRequest, *BucketConfig, bool) {
+	if _, ok := auth.VerifiedAdminOrRespond(c); !ok {
+		return LifecycleRequest{}, nil, false
+	}
+
+	var req LifecycleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Error("lifecycle request failed. failed to bind json", "err", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return LifecycleRequest{}, nil, false
+	}
+
+	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
+	if bucketConfig == nil {
+		return LifecycleRequest{}, nil, false
+	}
+
+	return req, bucketConfig, true
+}
+
+func previewLifecycleRule(ctx context.Context, mio *minio.Client, bucketName string, r LifecycleRule, sample int, now time.Time) (LifecycleRulePreview, error) {
+	p := LifecycleRulePreview{
+		ID:             r.ID,
+		Prefix:         r.Prefix,
+		Enabled:        r.Enabled,
+		TagsNotSampled: len(r.Tags) > 0,
+	}
+
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := mio.ListObjects(listCtx, bucketName, minio.ListObjectsOptions{
+		Prefix:    r.Prefix,
+		Recursive: true,
+	})
+
+	for obj := range ch {
+		if obj.Err != nil {
+			return p, obj.Err
+		}
+		if p.Matched >= sample {
+			p.Truncated = true
+			break
+		}
+
+		p.Matched++
+		p.MatchedBytes += obj.Size
+
+		if ruleDue(obj.LastModified, r.ExpirationDays, r.ExpirationDate, now) {
+			p.ExpiringNow++
+		}
+		if ruleDue(obj.LastModified, r.TransitionDays, r.TransitionDate, now) {
+			p.TransitionNow++
+		}
+	}
+
+	return p, nil
+}
+
+func ruleDue(lastModified time.Time, days int, date *time.Time, now time.Time) bool {
+	if days > 0 {
+		return lastModified.Add(time.Duration(days) * 24 * time.Hour).Before(now)
+	}
+	if date != nil {
+		return !date.After(now)
+	}
+	return false
+}
+
+func overlappingRules(r LifecycleRule, all []LifecycleRule) []string {
+	var out []string
+	for _, o := range all {
+		if o.ID == r.ID {
+			continue
+		}
+		if strings.HasPrefix(r.Prefix, o.Prefix) || strings.HasPrefix(o.Prefix, r.Prefix) {
+			out = append(out, o.ID)
+		}
+	}
+	return out
+}
+
+func validateLifecycleRules(rules []LifecycleRule) []string {
+	errs := make([]string, 0)
+	if len(rules) > lifecycleMaxRules {
+		errs = append(errs, fmt.Sprintf("at most %d rules are allowed", lifecycleMaxRules))
+	}
+
+	seen := make(map[string]bool, len(rules))
+	for i, r := range rules {
+		name := fmt.Sprintf("rule %d", i+1)
+		if r.ID != "" {
+			name = fmt.Sprintf("rule %q", r.ID)
+		}
+		fail := func(format string, args ...any) {
+			errs = append(errs, name+": "+fmt.Sprintf(format, args...))
+		}
+
+		switch {
+		case strings.TrimSpace(r.ID) == "":
+			fail("id is required")
+		case len(r.ID) > 255:
+			fail("id must be at most 255 characters")
+		case seen[r.ID]:
+			fail("duplicate id")
+		}
+		seen[r.ID] = true
+
+		if r.ExpirationDays < 0 || r.TransitionDays < 0 || r.NoncurrentExpirationDays < 0 ||
+			r.NoncurrentTransitionDays < 0 || r.AbortIncompleteMultipartDays < 0 {
+			fail("days must not be negative")
+		}
+
+		if r.ExpirationDays > 0 && r.ExpirationDate != nil {
+			fail("set either expiration_days or expiration_date, not both")
+		}
+		if r.ExpireDeleteMarkers && (r.ExpirationDays > 0 || r.ExpirationDate != nil) {
+			fail("expire_delete_markers cannot be combined with expiration_days or expiration_date")
+		}
+		if r.ExpireDeleteMarkers && len(r.Tags) > 0 {
+			fail("expire_delete_markers cannot be used with tag filters")
+		}
+		if !isMidnightUTC(r.ExpirationDate) {
+			fail("expiration_date must be midnight UTC")
+		}
+
+		hasTransition := r.TransitionDays > 0 || r.TransitionDate != nil
+		if r.TransitionDays > 0 && r.TransitionDate != nil {
+			fail("set either transition_days or transition_date, not both")
+		}
+		if hasTransition && strings.TrimSpace(r.TransitionStorageClass) == "" {
+			fail("transition_storage_class is required for transitions")
+		}
+		if !hasTransition && r.TransitionStorageClass != "" {
+			fail("transition_storage_class needs transition_days or transition_date")
+		}
+		if !isMidnightUTC(r.TransitionDate) {
+			fail("transition_date must be midnight UTC")
+		}
+		if r.ExpirationDays > 0 && r.TransitionDays > 0 && r.TransitionDays >= r.ExpirationDays {
+			fail("transition_days must be less than expiration_days")
+		}
+
+		if (r.NoncurrentTransitionDays > 0) != (r.NoncurrentTransitionStorageClass != "") {
+			fail("noncurrent transitions need both noncurrent_transition_days and noncurrent_transition_storage_class")
+		}
+		if r.AbortIncompleteMultipartDays > 0 && len(r.Tags) > 0 {
+			fail("abort_incomplete_multipart_days cannot be used with tag filters")
+		}
+
+		if r.ExpirationDays == 0 && r.ExpirationDate == nil && !r.ExpireDeleteMarkers && !hasTransition &&
+			r.NoncurrentExpirationDays == 0 && r.NoncurrentTransitionDays == 0 && r.AbortIncompleteMultipartDays == 0 {
+			fail("at least one action is required")
+		}
+
+		for k := range r.Tags {
+			if strings.TrimSpace(k) == "" {
+				fail("tag keys must not be empty")
+				break
+			}
+		}
+	}
+
+	return errs
+}
+
+func isMidnightUTC(t *time.Time) bool {
+	if t == nil {
+		return true
+	}
+	u := t.UTC()
+	return u.Hour() == 0 && u.Minute() == 0 && u.Second() == 0 && u.Nanosecond() == 0
+}
+
+func lifecycleRuleToMinio(r LifecycleRule) lifecycle.Rule {
+	out := lifecycle.Rule{
+		ID:     r.ID,
+		Status: "Disabled",
+	}
+	if r.Enabled {
+		out.Status = "Enabled"
+	}
+
+	tags := make([]lifecycle.Tag, 0, len(r.Tags))
+	for k, v := range r.Tags {
+		tags = append(tags, lifecycle.Tag{Key: k, Value: v})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key })
+
+	switch {
+	case len(tags) == 0:
+		out.RuleFilter = lifecycle.Filter{Prefix: r.Prefix}
+	case len(tags) == 1 && r.Prefix == "":
+		out.RuleFilter = lifecycle.Filter{Tag: tags[0]}
+	default:
+		out.RuleFilter = lifecycle.Filter{And: lifecycle.And{Prefix: r.Prefix, Tags: tags}}
+	}
+
+	out.Expiration.Days = lifecycle.ExpirationDays(r.ExpirationDays)
+	if r.ExpirationDate != nil {
+		out.Expiration.Date = lifecycle.ExpirationDate{Time: r.ExpirationDate.UTC()}
+	}
+	out.Expiration.DeleteMarker = lifecycle.ExpireDeleteMarker(r.ExpireDeleteMarkers)
+
+	if r.TransitionDays > 0 || r.TransitionDate != nil {
+		out.Transition.StorageClass = r.TransitionStorageClass
+		out.Transition.Days = lifecycle.ExpirationDays(r.TransitionDays)
+		if r.TransitionDate != nil {
+			out.Transition.Date = lifecycle.ExpirationDate{Time: r.TransitionDate.UTC()}
+		}
+	}
+
+	out.NoncurrentVersionExpiration.NoncurrentDays = lifecycle.ExpirationDays(r.NoncurrentExpirationDays)
+	if r.NoncurrentTransitionDays > 0 {
+		out.NoncurrentVersionTransition.NoncurrentDays = lifecycle.ExpirationDays(r.NoncurrentTransitionDays)
+		out.NoncurrentVersionTransition.StorageClass = r.NoncurrentTransitionStorageClass
+	}
+
+	out.AbortIncompleteMultipartUpload.DaysAfterInitiation = lifecycle.ExpirationDays(r.AbortIncompleteMultipartDays)
+
+	return out
+}
+
+func lifecycleRuleFromMinio(r lifecycle.Rule) LifecycleRule {
+	out := LifecycleRule{
+		ID:      r.ID,
+		Enabled: r.Status == "Enabled",
+	}
+
+	// Prefix can live in three places depending on how the rule was written.
+	out.Prefix = firstNonEmptyString(r.RuleFilter.And.Prefix, r.RuleFilter.Prefix, r.Prefix)
+
+	tags := r.RuleFilter.And.Tags
+	if !r.RuleFilter.Tag.IsEmpty() {
+		tags = append(tags, r.RuleFilter.Tag)
+	}
+	if len(tags) > 0 {
+		out.Tags = make(map[string]string, len(tags))
+		for _, t := range tags {
+			out.Tags[t.Key] = t.Value
+		}
+	}
+
+	out.ExpirationDays = int(r.Expiration.Days)
+	if !r.Expiration.IsDateNull() {
+		d := r.Expiration.Date.UTC()
+		out.ExpirationDate = &d
+	}
+	out.ExpireDeleteMarkers = r.Expiration.DeleteMarker.IsEnabled()
+
+	out.TransitionDays = int(r.Transition.Days)
+	if !r.Transition.IsDateNull() {
+		d := r.Transition.Date.UTC()
+		out.TransitionDate = &d
+	}
+	out.TransitionStorageClass = r.Transition.StorageClass
+
+	out.NoncurrentExpirationDays = int(r.NoncurrentVersionExpiration.NoncurrentDays)
+	out.NoncurrentTransitionDays = int(r.NoncurrentVersionTransition.NoncurrentDays)
+	out.NoncurrentTransitionStorageClass = r.NoncurrentVersionTransition.StorageClass
+	out.AbortIncompleteMultipartDays = int(r.AbortIncompleteMultipartUpload.DaysAfterInitiation)
+	out.Preserved = unmodeledLifecycleFields(r)
+
+	return out
+}
+
+// bucketLifecycle returns the bucket's lifecycle configuration, empty if it
+// has none.
+func bucketLifecycle(ctx context.Context, mio *minio.Client, bucketName string) (*lifecycle.Configuration, error) {
+	cfg, err := mio.GetBucketLifecycle(ctx, bucketName)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != "NoSuchLifecycleConfiguration" {
+			return nil, err
+		}
+		return lifecycle.NewConfiguration(), nil
+	}
+	return cfg, nil
+}
+
+// unmodeledLifecycleFields names the settings of r that LifecycleRule has no
+// field for.
+func unmodeledLifecycleFields(r lifecycle.Rule) []string {
+	var fields []string
+	if r.NoncurrentVersionExpiration.NewerNoncurrentVersions > 0 || r.NoncurrentVersionTransition.NewerNoncurrentVersions > 0 {
+		fields = append(fields, "newer_noncurrent_versions")
+	}
+	if lt, gt := objectSizeFilter(r.RuleFilter); lt > 0 || gt > 0 {
+		fields = append(fields, "object_size_filter")
+	}
+	if r.Expiration.DeleteAll.IsEnabled() {
+		fields = append(fields, "expired_object_all_versions")
+	}
+	if !r.DelMarkerExpiration.IsNull() {
+		fields = append(fields, "del_marker_expiration")
+	}
+	if !r.AllVersionsExpiration.IsNull() {
+		fields = append(fields, "all_versions_expiration")
+	}
+	return fields
+}
+
+// keepUnmodeledLifecycleFields copies the settings LifecycleRule can't
+// represent from the stored rule prev into out, so editing a rule in the UI
+// doesn't silently drop them.
+func keepUnmodeledLifecycleFields(out *lifecycle.Rule, prev lifecycle.Rule) {
+	out.NoncurrentVersionExpiration.NewerNoncurrentVersions = prev.NoncurrentVersionExpiration.NewerNoncurrentVersions
+	if !out.NoncurrentVersionTransition.IsDaysNull() {
+		out.NoncurrentVersionTransition.NewerNoncurrentVersions = prev.NoncurrentVersionTransition.NewerNoncurrentVersions
+	}
+	out.Expiration.DeleteAll = prev.Expiration.DeleteAll
+	out.DelMarkerExpiration = prev.DelMarkerExpiration
+	out.AllVersionsExpiration = prev.AllVersionsExpiration
+
+	lt, gt := objectSizeFilter(prev.RuleFilter)
+	if lt == 0 && gt == 0 {
+		return
+	}
+	// A filter with more than one condition must be an And.
+	f := out.RuleFilter
+	prefix := firstNonEmptyString(f.And.Prefix, f.Prefix)
+	tags := f.And.Tags
+	if !f.Tag.IsEmpty() {
+		tags = append(tags, f.Tag)
+	}
+	conditions := len(tags)
+	for _, set := range []bool{prefix != "", lt > 0, gt > 0} {
+		if set {
+			conditions++
+		}
+	}
+	if conditions == 1 {
+		out.RuleFilter = lifecycle.Filter{ObjectSizeLessThan: lt, ObjectSizeGreaterThan: gt}
+		return
+	}
+	out.RuleFilter = lifecycle.Filter{And: lifecycle.And{
+		Prefix:                prefix,
+		Tags:                  tags,
+		ObjectSizeLessThan:    lt,
+		ObjectSizeGreaterThan: gt,
+	}}
+}
+
+// objectSizeFilter returns a filter's size bounds, which can sit on the
+// filter itself or inside its And.
+func objectSizeFilter(f lifecycle.Filter) (lessThan, greaterThan int64) {
+	return max(f.ObjectSizeLessThan, f.And.ObjectSizeLessThan), max(f.ObjectSizeGreaterThan, f.And.ObjectSizeGreaterThan)
+}
+
+func firstNonEmptyString(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,457 @@
+package buckets
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"b0k3ts/internal/pkg/audit"
+	"b0k3ts/internal/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+// Object lock headers returned by HEAD/StatObject.
+const (
+	headerLockMode        = "X-Amz-Object-Lock-Mode"
+	headerLockRetainUntil = "X-Amz-Object-Lock-Retain-Until-Date"
+	headerLockLegalHold   = "X-Amz-Object-Lock-Legal-Hold"
+
+	// How many StatObject calls a lock status lookup runs at once.
+	lockStatusWorkers = 16
+	// The most keys one lock status lookup takes: a page of the object
+	// browser, not a whole listing.
+	lockStatusMaxKeys = 200
+
+	// A bucket's lock configuration is set when it's created and rarely
+	// changes, so it's cached per connection.
+	lockConfigCacheTTL = 5 * time.Minute
+)
+
+type ObjectLockConfigResponse struct {
+	Enabled  bool   `json:"enabled"`
+	Mode     string `json:"mode,omitempty"`     // default retention: GOVERNANCE or COMPLIANCE
+	Validity uint   `json:"validity,omitempty"` // default retention period
+	Unit     string `json:"unit,omitempty"`     // DAYS or YEARS
+}
+
+// ObjectLockStatus is the per-object lock state returned by stat, the lock
+// status lookup and the retention endpoints.
+type ObjectLockStatus struct {
+	Mode        string     `json:"mode,omitempty"` // GOVERNANCE or COMPLIANCE
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+	LegalHold   bool       `json:"legal_hold"`
+	Locked      bool       `json:"locked"` // retention still active or legal hold on
+}
+
+type ObjectLockConfigRequest struct {
+	Bucket string `json:"bucket"`
+}
+
+type ObjectLockStatusRequest struct {
+	Bucket string   `json:"bucket"`
+	Keys   []string `json:"keys"`
+}
+
+type ObjectLockStatusResponse struct {
+	Enabled bool                        `json:"enabled"`
+	Objects map[string]ObjectLockStatus `json:"objects"` // by key; empty unless enabled
+}
+
+type ObjectRetentionRequest struct {
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	VersionID string `json:"version_id,omitempty"`
+}
+
+type ObjectRetentionSetRequest struct {
+	Bucket           string     `json:"bucket"`
+	Key              string     `json:"key"`
+	VersionID        string     `json:"version_id,omitempty"`
+	Mode             string     `json:"mode"`         // GOVERNANCE or COMPLIANCE
+	RetainUntil      *time.Time `json:"retain_until"` // RFC 3339
+	GovernanceBypass bool       `json:"governance_bypass,omitempty"`
+}
+
+type ObjectLegalHoldSetRequest struct {
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	VersionID string `json:"version_id,omitempty"`
+	Enabled   bool   `json:"enabled"`
+}
+
+func (app *App) GetObjectLockConfig(c *gin.Context) {
+	var req ObjectLockConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
+	if bucketConfig == nil {
+		return
+	}
+
+	mio, err := Connect(*bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := cachedLockConfig(context.Background(), mio, *bucketConfig)
+	if err != nil {
+		slog.Error("failed to get object lock config", "err", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, res)
+}
+
+// GetObjectLockStatus returns the lock state of the given keys, usually the
+// page of objects the browser is showing. Listings don't include it, since
+// it costs one HEAD request per object.
+func (app *App) GetObjectLockStatus(c *gin.Context) {
+	var req ObjectLockStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Keys) > lockStatusMaxKeys {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("at most %d keys per request", lockStatusMaxKeys)})
+		return
+	}
+
+	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
+	if bucketConfig == nil {
+		return
+	}
+
+	mio, err := Connect(*bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+
+	cfg, err := cachedLockConfig(ctx, mio, *bucketConfig)
+	if err != nil {
+		slog.Error("failed to get object lock config", "err", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := ObjectLockStatusResponse{Enabled: cfg.Enabled, Objects: map[string]ObjectLockStatus{}}
+	if cfg.Enabled {
+		res.Objects = lockStatuses(ctx, mio, bucketConfig.BucketName, req.Keys)
+	}
+
+	c.JSON(200, res)
+}
+
+func (app *App) GetObjectRetention(c *gin.Context) {
+	var req ObjectRetentionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Key == "" {
+		c.JSON(400, gin.H{"error": "key is required"})
+		return
+	}
+
+	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
+	if bucketConfig == nil {
+		return
+	}
+
+	mio, err := Connect(*bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	st, err := mio.StatObject(context.Background(), bucketConfig.BucketName, req.Key, minio.StatObjectOptions{VersionID: req.VersionID})
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(404, gin.H{"error": "object not found"})
+		return
+	}
+
+	c.JSON(200, lockStatusFromInfo(st))
+}
+
+func (app *App) SetObjectRetention(c *gin.Context) {
+	var req ObjectRetentionSetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Key == "" {
+		c.JSON(400, gin.H{"error": "key is required"})
+		return
+	}
+
+	mode := minio.RetentionMode(strings.ToUpper(strings.TrimSpace(req.Mode)))
+	if !mode.IsValid() {
+		c.JSON(400, gin.H{"error": "mode must be GOVERNANCE or COMPLIANCE"})
+		return
+	}
+	if req.RetainUntil == nil || !req.RetainUntil.After(time.Now()) {
+		c.JSON(400, gin.H{"error": "retain_until must be in the future"})
+		return
+	}
+
+	userInfo, ok := tokenUserOrRespond(c)
+	if !ok {
+		return
+	}
+	if req.GovernanceBypass && !isVerifiedAdmin(c) {
+		c.JSON(403, gin.H{"error": "governance_bypass requires an administrator"})
+		return
+	}
+	// COMPLIANCE retention can't be shortened or removed by anyone, ever.
+	if mode == minio.Compliance && !isVerifiedAdmin(c) {
+		c.JSON(403, gin.H{"error": "COMPLIANCE retention requires an administrator"})
+		return
+	}
+
+	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
+	if bucketConfig == nil {
+		return
+	}
+
+	mio, err := Connect(*bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	until := req.RetainUntil.UTC()
+	err = mio.PutObjectRetention(context.Background(), bucketConfig.BucketName, req.Key, minio.PutObjectRetentionOptions{
+		GovernanceBypass: req.GovernanceBypass,
+		Mode:             &mode,
+		RetainUntilDate:  &until,
+		VersionID:        req.VersionID,
+	})
+	if err != nil {
+		slog.Error("failed to set object retention", "key", req.Key, "err", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	slog.Info("object retention set", "bucket", req.Bucket, "key", req.Key, "mode", mode, "until", until, "user", userInfo.Email)
+	if err := audit.Record(app.DB, userInfo.Email, "object_retention_set", req.Bucket, map[string]string{
+		"key":               req.Key,
+		"version_id":        req.VersionID,
+		"mode":              string(mode),
+		"retain_until":      until.Format(time.RFC3339),
+		"governance_bypass": strconv.FormatBool(req.GovernanceBypass),
+	}); err != nil {
+		slog.Error("failed to record object retention audit event", "err", err)
+	}
+	c.JSON(200, gin.H{"message": "Object retention updated"})
+}
+
+func (app *App) SetObjectLegalHold(c *gin.Context) {
+	var req ObjectLegalHoldSetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Key == "" {
+		c.JSON(400, gin.H{"error": "key is required"})
+		return
+	}
+
+	userInfo, ok := tokenUserOrRespond(c)
+	if !ok {
+		return
+	}
+	if !req.Enabled && !isVerifiedAdmin(c) {
+		c.JSON(403, gin.H{"error": "removing a legal hold requires an administrator"})
+		return
+	}
+
+	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
+	if bucketConfig == nil {
+		return
+	}
+
+	mio, err := Connect(*bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	status := minio.LegalHoldDisabled
+	if req.Enabled {
+		status = minio.LegalHoldEnabled
+	}
+
+	err = mio.PutObjectLegalHold(context.Background(), bucketConfig.BucketName, req.Key, minio.PutObjectLegalHoldOptions{
+		VersionID: req.VersionID,
+		Status:    &status,
+	})
+	if err != nil {
+		slog.Error("failed to set legal hold", "key", req.Key, "err", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	slog.Info("legal hold set", "bucket", req.Bucket, "key", req.Key, "enabled", req.Enabled, "user", userInfo.Email)
+	if err := audit.Record(app.DB, userInfo.Email, "object_legal_hold_set", req.Bucket, map[string]string{
+		"key":        req.Key,
+		"version_id": req.VersionID,
+		"enabled":    strconv.FormatBool(req.Enabled),
+	}); err != nil {
+		slog.Error("failed to record legal hold audit event", "err", err)
+	}
+
+	c.JSON(200, gin.H{"message": "Legal hold updated"})
+}
+
+func objectLockConfig(ctx context.Context, mio *minio.Client, bucketName string) (ObjectLockConfigResponse, error) {
+	enabled, mode, validity, unit, err := mio.GetObjectLockConfig(ctx, bucketName)
+	if err != nil {
+		// Buckets created without object lock answer with this error code.
+		if minio.ToErrorResponse(err).Code == "ObjectLockConfigurationNotFoundError" {
+			return ObjectLockConfigResponse{}, nil
+		}
+		return ObjectLockConfigResponse{}, err
+	}
+
+	res := ObjectLockConfigResponse{Enabled: enabled == "Enabled"}
+	if mode != nil {
+		res.Mode = mode.String()
+	}
+	if validity != nil {
+		res.Validity = *validity
+	}
+	if unit != nil {
+		res.Unit = unit.String()
+	}
+	return res, nil
+}
+
+func lockStatusFromInfo(info minio.ObjectInfo) ObjectLockStatus {
+	st := ObjectLockStatus{
+		Mode:      info.Metadata.Get(headerLockMode),
+		LegalHold: strings.EqualFold(info.Metadata.Get(headerLockLegalHold), string(minio.LegalHoldEnabled)),
+	}
+	if v := info.Metadata.Get(headerLockRetainUntil); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			st.RetainUntil = &t
+		}
+	}
+	st.Locked = st.LegalHold || (st.RetainUntil != nil && st.RetainUntil.After(time.Now()))
+	return st
+}
+
+// checkDeleteAllowed refuses deletes that the backend would reject because of
+// object lock, so users get a clear message instead of a raw S3 error.
+func checkDeleteAllowed(ctx context.Context, mio *minio.Client, bucketName, key string, governanceBypass bool) error {
+	info, err := mio.StatObject(ctx, bucketName, key, minio.StatObjectOptions{})
+	if err != nil {
+		// Let the delete itself report missing objects.
+		return nil
+	}
+
+	st := lockStatusFromInfo(info)
+	if st.LegalHold {
+		return httpError{status: 409, msg: "object is under legal hold and cannot be deleted"}
+	}
+	if st.RetainUntil == nil || !st.RetainUntil.After(time.Now()) {
+		return nil
+	}
+	if st.Mode == string(minio.Governance) && governanceBypass {
+		return nil
+	}
+	return httpError{
+		status: 409,
+		msg:    fmt.Sprintf("object is locked in %s mode until %s and cannot be deleted", st.Mode, st.RetainUntil.Format(time.RFC3339)),
+	}
+}
+
+// lockStatuses looks up the lock state of each key. Keys that can't be
+// read are left out.
+func lockStatuses(ctx context.Context, mio *minio.Client, bucketName string, keys []string) map[string]ObjectLockStatus {
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		res = make(map[string]ObjectLockStatus, len(keys))
+	)
+	sem := make(chan struct{}, lockStatusWorkers)
+
+	for _, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			info, err := mio.StatObject(ctx, bucketName, key, minio.StatObjectOptions{})
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			res[key] = lockStatusFromInfo(info)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return res
+}
+
+// isVerifiedAdmin reports whether the caller's token is valid and names an
+// administrator. Lock overrides weaken retention or fix it for good, so the
+// token's claims alone aren't trusted for them.
+func isVerifiedAdmin(c *gin.Context) bool {
+	userInfo, err := auth.VerifiedUser(c)
+	return err == nil && userInfo.Administrator
+}
+
+// --- Lock config cache ---
+
+type lockConfigEntry struct {
+	version int64
+	fetched time.Time
+	cfg     ObjectLockConfigResponse
+}
+
+var lockConfigs = struct {
+	sync.Mutex
+	entries map[string]lockConfigEntry
+}{entries: map[string]lockConfigEntry{}}
+
+// cachedLockConfig returns the bucket's lock configuration, fetching it at
+// most once per TTL per connection version.
+func cachedLockConfig(ctx context.Context, mio *minio.Client, cfg BucketConfig) (ObjectLockConfigResponse, error) {
+	lockConfigs.Lock()
+	e, ok := lockConfigs.entries[cfg.BucketName]
+	lockConfigs.Unlock()
+	if ok && e.version == cfg.Version && time.Since(e.fetched) < lockConfigCacheTTL {
+		return e.cfg, nil
+	}
+
+	res, err := objectLockConfig(ctx, mio, cfg.BucketName)
+	if err != nil {
+		return ObjectLockConfigResponse{}, err
+	}
+
+	lockConfigs.Lock()
+	lockConfigs.entries[cfg.BucketName] = lockConfigEntry{version: cfg.Version, fetched: time.Now(), cfg: res}
+	lockConfigs.Unlock()
+	return res, nil
+}
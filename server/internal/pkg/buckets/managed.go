@@ -0,0 +1,208 @@
+package buckets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+
+	"b0k3ts/configs"
+	"b0k3ts/internal/pkg/audit"
+	"b0k3ts/internal/pkg/storage"
+)
+
+// ManagedActor is the audit actor for changes made from config.yaml.
+const ManagedActor = "config.yaml"
+
+// SecretRefs are a managed connection's secrets as declared in config.yaml.
+// They are stored in place of the values and resolved each time a client is
+// built, so rotating the env var or file needs no reconcile.
+type SecretRefs struct {
+	SecretAccessKey configs.SecretRef `json:"secret_access_key"`
+	ClientKey       configs.SecretRef `json:"client_key"`
+}
+
+var errManagedConnection = httpError{status: 409, msg: "connection is managed by config.yaml and can't be changed here"}
+
+// ReconcileConnections makes the managed connections in storage match the
+// connections list from config.yaml: declared ones are created or updated,
+// managed ones no longer declared are deleted. Connections added through the
+// API are left alone unless the file declares the same bucket, in which case
+// the file takes them over.
+func ReconcileConnections(db storage.Store, declared []configs.ConnectionConfig) error {
+	stored, err := connectionRepo(db).List()
+	if err != nil {
+		return err
+	}
+	existing := map[string]BucketConfig{}
+	for _, cfg := range stored {
+		existing[cfg.BucketName] = cfg
+	}
+
+	var errs []error
+	seen := map[string]bool{}
+	for _, decl := range declared {
+		if decl.Bucket == "" {
+			errs = append(errs, errors.New("connections: bucket is required"))
+			continue
+		}
+		if seen[decl.Bucket] {
+			errs = append(errs, fmt.Errorf("connections: %s is declared more than once", decl.Bucket))
+			continue
+		}
+		seen[decl.Bucket] = true
+
+		// A declared connection that fails to resolve keeps its previous
+		// state rather than being pruned.
+		cfg, err := managedBucketConfig(decl)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("connections: %s: %w", decl.Bucket, err))
+			continue
+		}
+
+		prev, ok := existing[cfg.BucketName]
+		if ok {
+			cfg.Version = prev.Version
+			if reflect.DeepEqual(prev, cfg) {
+				continue
+			}
+			if !prev.Managed {
+				slog.Warn("config.yaml takes over an existing connection", "bucket", cfg.BucketName)
+			}
+		}
+
+		if _, err := saveBucketConfig(db, &cfg); err != nil {
+			errs = append(errs, fmt.Errorf("connections: %s: %w", decl.Bucket, err))
+			continue
+		}
+
+		change := "created"
+		if ok {
+			change = "updated"
+		}
+		recordReconcile(db, cfg.BucketName, change)
+		slog.Info("managed connection reconciled", "bucket", cfg.BucketName, "change", change)
+	}
+
+	for name, cfg := range existing {
+		if !cfg.Managed || seen[name] {
+			continue
+		}
+		if err := deleteConnection(db, name); err != nil {
+			errs = append(errs, fmt.Errorf("connections: prune %s: %w", name, err))
+			continue
+		}
+
+		recordReconcile(db, name, "pruned")
+		slog.Info("managed connection reconciled", "bucket", name, "change", "pruned")
+	}
+
+	return errors.Join(errs...)
+}
+
+func recordReconcile(db storage.Store, bucket, change string) {
+	if err := audit.Record(db, ManagedActor, "connection_reconcile", bucket, map[string]string{"change": change}); err != nil {
+		slog.Error("failed to record connection reconcile audit event", "err", err)
+	}
+}
+
+// managedBucketConfig resolves a declared connection's secret references and
+// validates it like add_connection would.
+func managedBucketConfig(decl configs.ConnectionConfig) (BucketConfig, error) {
+	cfg := BucketConfig{
+		BucketId:         decl.Bucket,
+		Endpoint:         decl.Endpoint,
+		Secure:           decl.Secure,
+		BucketName:       decl.Bucket,
+		Location:         decl.Location,
+		BucketLookup:     decl.BucketLookup,
+		AuthorizedUsers:  decl.AuthorizedUsers,
+		AuthorizedGroups: decl.AuthorizedGroups,
+		Managed:          true,
+	}
+	if cfg.Endpoint == "" {
+		return BucketConfig{}, errors.New("endpoint is required")
+	}
+
+	var err error
+	if cfg.AccessKeyId, err = decl.AccessKeyId.Resolve(); err != nil {
+		return BucketConfig{}, fmt.Errorf("accessKeyId: %w", err)
+	}
+	if cfg.SecretAccessKey, err = decl.SecretAccessKey.Resolve(); err != nil {
+		return BucketConfig{}, fmt.Errorf("secretAccessKey: %w", err)
+	}
+
+	if t := decl.TLS; t != nil {
+		cfg.TLS = &TLSConfig{ServerName: t.ServerName, InsecureSkipVerify: t.InsecureSkipVerify}
+		if cfg.TLS.CABundle, err = t.CABundle.Resolve(); err != nil {
+			return BucketConfig{}, fmt.Errorf("tls.caBundle: %w", err)
+		}
+		if cfg.TLS.ClientCert, err = t.ClientCert.Resolve(); err != nil {
+			return BucketConfig{}, fmt.Errorf("tls.clientCert: %w", err)
+		}
+		if cfg.TLS.ClientKey, err = t.ClientKey.Resolve(); err != nil {
+			return BucketConfig{}, fmt.Errorf("tls.clientKey: %w", err)
+		}
+	}
+
+	if decl.Credentials != nil {
+		cfg.Credentials = &CredentialsConfig{}
+		if err := remarshal(decl.Credentials, cfg.Credentials); err != nil {
+			return BucketConfig{}, fmt.Errorf("credentials: %w", err)
+		}
+	}
+	if decl.Encryption != nil {
+		cfg.Encryption = &EncryptionConfig{}
+		if err := remarshal(decl.Encryption, cfg.Encryption); err != nil {
+			return BucketConfig{}, fmt.Errorf("encryption: %w", err)
+		}
+	}
+
+	if err := validateBucketConfig(cfg); err != nil {
+		return BucketConfig{}, err
+	}
+
+	// Validated with the resolved secrets; stored with the references.
+	cfg.SecretAccessKey = ""
+	cfg.SecretRefs = &SecretRefs{SecretAccessKey: decl.SecretAccessKey}
+	if cfg.TLS != nil {
+		cfg.TLS.ClientKey = ""
+		cfg.SecretRefs.ClientKey = decl.TLS.ClientKey
+	}
+	return cfg, nil
+}
+
+// withSecrets returns cfg with its secret references resolved. The TLS
+// settings are copied so the stored config is left alone.
+func (cfg BucketConfig) withSecrets() (BucketConfig, error) {
+	refs := cfg.SecretRefs
+	if refs == nil {
+		return cfg, nil
+	}
+
+	var err error
+	if cfg.SecretAccessKey, err = refs.SecretAccessKey.Resolve(); err != nil {
+		return BucketConfig{}, fmt.Errorf("%s: secretAccessKey: %w", cfg.BucketName, err)
+	}
+	if cfg.TLS != nil {
+		t := *cfg.TLS
+		if t.ClientKey, err = refs.ClientKey.Resolve(); err != nil {
+			return BucketConfig{}, fmt.Errorf("%s: tls.clientKey: %w", cfg.BucketName, err)
+		}
+		cfg.TLS = &t
+	}
+	return cfg, nil
+}
+
+// remarshal converts a YAML map into one of the API's JSON types.
+func remarshal(in map[string]any, out any) error {
+	raw, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	return dec.Decode(out)
+}
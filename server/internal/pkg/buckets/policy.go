@@ -0,0 +1,555 @@
+package buckets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"b0k3ts/internal/pkg/audit"
+	"b0k3ts/internal/pkg/auth"
+	"b0k3ts/internal/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+// PolicyStatusPrefix stores the last known public/private state of each
+// connection's bucket policy, so the connection list can flag public buckets
+// without calling S3 for every entry.
+const PolicyStatusPrefix = storage.NSPolicyStatus
+
+const (
+	PolicyPresetPrivate    = "private"
+	PolicyPresetPublicRead = "public-read"
+
+	policyVersion = "2012-10-17"
+
+	policySeverityError   = "error"
+	policySeverityWarning = "warning"
+	policySeverityInfo    = "info"
+
+	policyStatusCheckTimeout = 10 * time.Second
+	// How many policies a refreshed connection list fetches at once.
+	policyRefreshWorkers = 8
+)
+
+// BucketPolicyStatus is what the connection list shows about a bucket policy.
+type BucketPolicyStatus struct {
+	Public         bool      `json:"public"`
+	PublicRead     bool      `json:"public_read"`
+	PublicWrite    bool      `json:"public_write"`
+	PublicList     bool      `json:"public_list"`
+	PublicPrefixes []string  `json:"public_prefixes,omitempty"` // "" means the whole bucket
+	CheckedAt      time.Time `json:"checked_at"`
+}
+
+type PolicyFinding struct {
+	Severity  string `json:"severity"`      // error, warning or info
+	Statement int    `json:"statement"`     // index into Statement; -1 for the whole document
+	Sid       string `json:"sid,omitempty"` // statement Sid, if any
+	Message   string `json:"message"`
+}
+
+type PolicyLintResult struct {
+	Valid          bool            `json:"valid"` // no error-level findings
+	Public         bool            `json:"public"`
+	PublicRead     bool            `json:"public_read"`
+	PublicWrite    bool            `json:"public_write"`
+	PublicList     bool            `json:"public_list"`
+	PublicPrefixes []string        `json:"public_prefixes,omitempty"`
+	Findings       []PolicyFinding `json:"findings"`
+}
+
+type BucketPolicyRequest struct {
+	Bucket string          `json:"bucket"`
+	Policy json.RawMessage `json:"policy,omitempty"` // policy document, as an object or a JSON string
+}
+
+type BucketPolicySetRequest struct {
+	Bucket        string          `json:"bucket"`
+	Policy        json.RawMessage `json:"policy,omitempty"`         // either a policy ...
+	Preset        string          `json:"preset,omitempty"`         // ... or "private" / "public-read"
+	Prefix        string          `json:"prefix,omitempty"`         // public-read only; empty = whole bucket
+	ConfirmPublic bool            `json:"confirm_public,omitempty"` // required when the result is public
+}
+
+type BucketPolicyResponse struct {
+	Bucket string           `json:"bucket"`
+	Policy json.RawMessage  `json:"policy"` // null when no policy is set
+	Lint   PolicyLintResult `json:"lint"`
+}
+
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	Id        string            `json:"Id,omitempty"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+type policyStatement struct {
+	Sid          string          `json:"Sid,omitempty"`
+	Effect       string          `json:"Effect"`
+	Principal    json.RawMessage `json:"Principal,omitempty"`
+	NotPrincipal json.RawMessage `json:"NotPrincipal,omitempty"`
+	Action       stringOrSlice   `json:"Action,omitempty"`
+	NotAction    stringOrSlice   `json:"NotAction,omitempty"`
+	Resource     stringOrSlice   `json:"Resource,omitempty"`
+	NotResource  stringOrSlice   `json:"NotResource,omitempty"`
+	Condition    json.RawMessage `json:"Condition,omitempty"`
+}
+
+// stringOrSlice accepts both `"a"` and `["a", "b"]`, as IAM policies do.
+type stringOrSlice []string
+
+func (s *stringOrSlice) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*s = []string{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return errors.New("expected a string or a list of strings")
+	}
+	*s = many
+	return nil
+}
+
+// GetBucketPolicy returns the bucket policy with its lint result and refreshes
+// the stored public/private status.
+func (app *App) GetBucketPolicy(c *gin.Context) {
+	var req BucketPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
+	if bucketConfig == nil {
+		return
+	}
+
+	mio, err := Connect(*bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	raw, err := mio.GetBucketPolicy(context.Background(), bucketConfig.BucketName)
+	if err != nil {
+		slog.Error("failed to get bucket policy", "err", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := BucketPolicyResponse{Bucket: req.Bucket, Policy: json.RawMessage("null")}
+	if strings.TrimSpace(raw) != "" {
+		res.Policy = json.RawMessage(raw)
+	}
+	res.Lint = lintBucketPolicy(raw, bucketConfig.BucketName)
+
+	app.savePolicyStatus(req.Bucket, res.Lint)
+	c.JSON(200, res)
+}
+
+// LintBucketPolicy checks a policy document without applying it.
+func (app *App) LintBucketPolicy(c *gin.Context) {
+	var req BucketPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
+	if bucketConfig == nil {
+		return
+	}
+
+	doc, err := policyText(req.Policy)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, lintBucketPolicy(doc, bucketConfig.BucketName))
+}
+
+// SetBucketPolicy replaces the bucket policy from a document or a preset.
+// Policies that make data public need confirm_public; every change is
+// written to the audit trail.
+func (app *App) SetBucketPolicy(c *gin.Context) {
+	userInfo, ok := auth.VerifiedAdminOrRespond(c)
+	if !ok {
+		return
+	}
+
+	var req BucketPolicySetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
+	if bucketConfig == nil {
+		return
+	}
+
+	doc, err := policyFromSetRequest(req, bucketConfig.BucketName)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	lint := lintBucketPolicy(doc, bucketConfig.BucketName)
+	if !lint.Valid {
+		c.JSON(400, gin.H{"error": "policy has errors", "lint": lint})
+		return
+	}
+	if lint.Public && !req.ConfirmPublic {
+		c.JSON(409, gin.H{"error": "policy makes data public; resend with confirm_public to apply it", "lint": lint})
+		return
+	}
+
+	mio, err := Connect(*bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// An empty policy removes it.
+	if err := mio.SetBucketPolicy(context.Background(), bucketConfig.BucketName, doc); err != nil {
+		slog.Error("failed to set bucket policy", "err", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	app.savePolicyStatus(req.Bucket, lint)
+
+	details := map[string]string{
+		"public":       fmt.Sprint(lint.Public),
+		"public_read":  fmt.Sprint(lint.PublicRead),
+		"public_write": fmt.Sprint(lint.PublicWrite),
+		"public_list":  fmt.Sprint(lint.PublicList),
+		"policy":       doc,
+	}
+	if req.Preset != "" {
+		details["preset"] = req.Preset
+		details["prefix"] = req.Prefix
+	}
+	if err := audit.Record(app.DB, userInfo.Email, "bucket_policy_set", req.Bucket, details); err != nil {
+		slog.Error("failed to record bucket policy audit event", "err", err)
+	}
+
+	c.JSON(200, gin.H{"message": "Bucket policy updated", "lint": lint})
+}
+
+// policyText turns the request's policy field into the document text. It
+// accepts a JSON object or a JSON string holding one.
+func policyText(raw json.RawMessage) (string, error) {
+	trimmed := strings.TrimSpace(string(raw))
+	if trimmed == "" || trimmed == "null" {
+		return "", nil
+	}
+	if strings.HasPrefix(trimmed, `"`) {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(s), nil
+	}
+	return trimmed, nil
+}
+
+func policyFromSetRequest(req BucketPolicySetRequest, bucketName string) (string, error) {
+	hasPolicy := len(strings.TrimSpace(string(req.Policy))) > 0 && strings.TrimSpace(string(req.Policy)) != "null"
+	if hasPolicy == (req.Preset != "") {
+		return "", errors.New("provide either policy or preset")
+	}
+	if hasPolicy {
+		return policyText(req.Policy)
+	}
+	return policyPreset(req.Preset, bucketName, req.Prefix)
+}
+
+// policyPreset builds one of the canned policies. "private" is the empty
+// policy, which removes any existing one.
+func policyPreset(preset, bucketName, prefix string) (string, error) {
+	switch preset {
+	case PolicyPresetPrivate:
+		return "", nil
+	case PolicyPresetPublicRead:
+		prefix = strings.TrimLeft(prefix, "/")
+		if strings.ContainsAny(prefix, "*?") {
+			return "", errors.New("prefix must not contain wildcards")
+		}
+		doc := policyDocument{
+			Version: policyVersion,
+			Statement: []policyStatement{{
+				Sid:       "PublicRead",
+				Effect:    "Allow",
+				Principal: json.RawMessage(`{"AWS":["*"]}`),
+				Action:    stringOrSlice{"s3:GetObject"},
+				Resource:  stringOrSlice{"arn:aws:s3:::" + bucketName + "/" + prefix + "*"},
+			}},
+		}
+		b, err := json.Marshal(doc)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("unknown preset %q (use %q or %q)", preset, PolicyPresetPrivate, PolicyPresetPublicRead)
+	}
+}
+
+// lintBucketPolicy checks a bucket policy and works out whether it grants
+// anonymous access. An empty document is a valid, private policy.
+func lintBucketPolicy(doc, bucketName string) PolicyLintResult {
+	res := PolicyLintResult{Valid: true, Findings: make([]PolicyFinding, 0)}
+	add := func(severity string, idx int, sid, format string, args ...any) {
+		res.Findings = append(res.Findings, PolicyFinding{Severity: severity, Statement: idx, Sid: sid, Message: fmt.Sprintf(format, args...)})
+		if severity == policySeverityError {
+			res.Valid = false
+		}
+	}
+
+	if strings.TrimSpace(doc) == "" {
+		add(policySeverityInfo, -1, "", "no bucket policy; only credentialed access is allowed")
+		return res
+	}
+
+	var p policyDocument
+	if err := json.Unmarshal([]byte(doc), &p); err != nil {
+		add(policySeverityError, -1, "", "policy is not valid JSON: %v", err)
+		return res
+	}
+	if p.Version != policyVersion {
+		add(policySeverityWarning, -1, "", "Version should be %q", policyVersion)
+	}
+	if len(p.Statement) == 0 {
+		add(policySeverityError, -1, "", "policy has no statements")
+		return res
+	}
+
+	prefixes := map[string]bool{}
+	for i, st := range p.Statement {
+		effect := strings.TrimSpace(st.Effect)
+		if effect != "Allow" && effect != "Deny" {
+			add(policySeverityError, i, st.Sid, "Effect must be Allow or Deny")
+			continue
+		}
+		if len(st.Principal) == 0 && len(st.NotPrincipal) == 0 {
+			add(policySeverityError, i, st.Sid, "bucket policy statements need a Principal")
+		}
+		if len(st.Action) == 0 && len(st.NotAction) == 0 {
+			add(policySeverityError, i, st.Sid, "statement has no Action")
+		}
+		if len(st.Resource) == 0 && len(st.NotResource) == 0 {
+			add(policySeverityError, i, st.Sid, "statement has no Resource")
+		}
+		for _, r := range st.Resource {
+			if _, ok := resourceObjectPath(r, bucketName); !ok && !resourceIsBucket(r, bucketName) {
+				add(policySeverityWarning, i, st.Sid, "resource %q does not refer to bucket %q", r, bucketName)
+			}
+		}
+
+		if effect != "Allow" {
+			continue
+		}
+
+		if len(st.NotPrincipal) > 0 {
+			add(policySeverityWarning, i, st.Sid, "Allow with NotPrincipal grants access to everyone not listed")
+		}
+		if len(st.NotAction) > 0 {
+			add(policySeverityWarning, i, st.Sid, "Allow with NotAction grants every action not listed")
+		}
+		if policyActionsMatch(st.Action, "s3:*") {
+			add(policySeverityWarning, i, st.Sid, "statement allows all S3 actions")
+		}
+
+		public := principalIsPublic(st.Principal) || len(st.NotPrincipal) > 0
+		if !public {
+			continue
+		}
+		if len(st.Condition) > 0 && string(st.Condition) != "{}" {
+			add(policySeverityWarning, i, st.Sid, "statement grants access to everyone (Principal: *) behind a Condition; check that the condition really limits it")
+			continue
+		}
+
+		read := policyActionsMatch(st.Action, "s3:GetObject") || len(st.NotAction) > 0
+		write := policyActionsMatch(st.Action, "s3:PutObject") || policyActionsMatch(st.Action, "s3:DeleteObject") || len(st.NotAction) > 0
+		list := policyActionsMatch(st.Action, "s3:ListBucket") || len(st.NotAction) > 0
+
+		res.Public = true
+		res.PublicRead = res.PublicRead || read
+		res.PublicWrite = res.PublicWrite || write
+		res.PublicList = res.PublicList || list
+
+		switch {
+		case write:
+			add(policySeverityWarning, i, st.Sid, "statement lets anyone write or delete objects (Principal: *)")
+		case list:
+			add(policySeverityWarning, i, st.Sid, "statement lets anyone list the bucket (Principal: *)")
+		default:
+			add(policySeverityWarning, i, st.Sid, "statement grants access to everyone (Principal: *)")
+		}
+
+		for _, r := range st.Resource {
+			if p, ok := resourceObjectPath(r, bucketName); ok {
+				prefixes[strings.TrimSuffix(p, "*")] = true
+			} else if resourceIsBucket(r, bucketName) && list {
+				prefixes[""] = true
+			}
+		}
+	}
+
+	for p := range prefixes {
+		res.PublicPrefixes = append(res.PublicPrefixes, p)
+	}
+	sort.Strings(res.PublicPrefixes)
+	return res
+}
+
+// principalIsPublic reports whether a Principal is "*" or {"AWS": "*"}.
+func principalIsPublic(raw json.RawMessage) bool {
+	if len(raw) == 0 {
+		return false
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s == "*"
+	}
+
+	var m map[string]stringOrSlice
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return false
+	}
+	for _, v := range m {
+		for _, p := range v {
+			if p == "*" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// policyActionsMatch reports whether any action pattern (which may use * and
+// ?) covers action.
+func policyActionsMatch(patterns []string, action string) bool {
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "*" {
+			return true
+		}
+		if ok, _ := path.Match(p, strings.ToLower(action)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func resourceIsBucket(resource, bucketName string) bool {
+	return resource == "*" || resource == "arn:aws:s3:::"+bucketName || resource == "arn:aws:s3:::*"
+}
+
+// resourceObjectPath returns the object part of "arn:aws:s3:::bucket/path".
+func resourceObjectPath(resource, bucketName string) (string, bool) {
+	if resource == "*" || resource == "arn:aws:s3:::*" {
+		return "*", true
+	}
+	rest, ok := strings.CutPrefix(resource, "arn:aws:s3:::"+bucketName+"/")
+	return rest, ok
+}
+
+func (app *App) savePolicyStatus(bucketID string, lint PolicyLintResult) {
+	st := BucketPolicyStatus{
+		Public:         lint.Public,
+		PublicRead:     lint.PublicRead,
+		PublicWrite:    lint.PublicWrite,
+		PublicList:     lint.PublicList,
+		PublicPrefixes: lint.PublicPrefixes,
+		CheckedAt:      time.Now().UTC(),
+	}
+	if err := policyStatusRepo(app.DB).Put(bucketID, st); err != nil {
+		slog.Error("failed to save bucket policy status", "bucket", bucketID, "err", err)
+	}
+}
+
+// loadPolicyStatus returns the last stored status, or nil if the policy has
+// never been checked.
+func loadPolicyStatus(db storage.Store, bucketID string) *BucketPolicyStatus {
+	st, err := policyStatusRepo(db).Get(bucketID)
+	if err != nil {
+		if !storage.IsNotFound(err) {
+			slog.Error("failed to load bucket policy status", "bucket", bucketID, "err", err)
+		}
+		return nil
+	}
+	return &st
+}
+
+// refreshPolicyStatus fetches the live policy for a connection and stores the
+// result. Errors leave the previous status in place.
+func (app *App) refreshPolicyStatus(ctx context.Context, cfg BucketConfig) *BucketPolicyStatus {
+	mio, err := Connect(cfg)
+	if err != nil {
+		return loadPolicyStatus(app.DB, cfg.BucketName)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, policyStatusCheckTimeout)
+	defer cancel()
+
+	raw, err := mio.GetBucketPolicy(ctx, cfg.BucketName)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != "AccessDenied" {
+			slog.Error("failed to refresh bucket policy status", "bucket", cfg.BucketName, "err", err)
+		}
+		return loadPolicyStatus(app.DB, cfg.BucketName)
+	}
+
+	app.savePolicyStatus(cfg.BucketName, lintBucketPolicy(raw, cfg.BucketName))
+	return loadPolicyStatus(app.DB, cfg.BucketName)
+}
+
+// withPolicyStatus attaches the stored policy status to each connection. With
+// refresh set, the live policies are fetched first.
+func (app *App) withPolicyStatus(cfgs []BucketConfig, refresh bool) []BucketConnection {
+	out := make([]BucketConnection, len(cfgs))
+	for i, cfg := range cfgs {
+		out[i] = BucketConnection{BucketConfig: cfg}
+	}
+
+	if !refresh {
+		for i := range out {
+			out[i].Policy = loadPolicyStatus(app.DB, out[i].BucketName)
+		}
+		return out
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, policyRefreshWorkers)
+
+	for i := range out {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(bc *BucketConnection) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			bc.Policy = app.refreshPolicyStatus(context.Background(), bc.BucketConfig)
+		}(&out[i])
+	}
+	wg.Wait()
+
+	return out
+}
@@ -0,0 +1,463 @@
+package buckets
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"b0k3ts/internal/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+const PreviewCachePrefix = storage.NSPreviews
+
+const (
+	PreviewKindImage    = "image"
+	PreviewKindText     = "text"
+	PreviewKindMetadata = "metadata"
+)
+
+const (
+	previewDefaultTextBytes = 64 << 10 // 64 KiB
+	previewMaxTextBytes     = 1 << 20  // 1 MiB
+	previewDefaultDimension = 256
+	previewMaxDimension     = 1024
+	previewMaxImageBytes    = 32 << 20 // refuse to decode larger source images
+	previewMaxImagePixels   = 50_000_000
+	previewMaxCSVRows       = 100
+	previewCacheTTL         = 7 * 24 * time.Hour
+)
+
+type PreviewRequest struct {
+	Bucket   string `json:"bucket"`              // bucket connection id
+	Key      string `json:"key"`                 // object key/path in the bucket
+	MaxBytes int    `json:"max_bytes,omitempty"` // optional; text preview length, default 64 KiB
+	Size     int    `json:"size,omitempty"`      // optional; thumbnail bounding box in pixels, default 256
+
+	SSECustomerKey string `json:"sse_customer_key,omitempty"` // required for SSE-C objects
+}
+
+type PreviewImage struct {
+	Format       string `json:"format"` // source format: jpeg, png, gif
+	Width        int    `json:"width"`  // source dimensions
+	Height       int    `json:"height"`
+	ThumbWidth   int    `json:"thumb_width"`
+	ThumbHeight  int    `json:"thumb_height"`
+	ThumbnailPNG []byte `json:"thumbnail_png"` // base64 in JSON
+	Cached       bool   `json:"cached"`
+}
+
+type PreviewText struct {
+	Encoding  string     `json:"encoding"` // utf-8, utf-16le, utf-16be, iso-8859-1
+	Content   string     `json:"content"`
+	Truncated bool       `json:"truncated"`
+	Format    string     `json:"format,omitempty"` // "csv" or "json" when recognised
+	Rows      [][]string `json:"rows,omitempty"`   // parsed CSV rows (bounded)
+	ValidJSON *bool      `json:"valid_json,omitempty"`
+}
+
+type PreviewResponse struct {
+	Bucket       string        `json:"bucket"`
+	Key          string        `json:"key"`
+	Kind         string        `json:"kind"`
+	Size         int64         `json:"size"`
+	ContentType  string        `json:"content_type"`
+	ETag         string        `json:"etag"`
+	LastModified time.Time     `json:"last_modified"`
+	Image        *PreviewImage `json:"image,omitempty"`
+	Text         *PreviewText  `json:"text,omitempty"`
+}
+
+// Preview returns a bounded-size rendition of an object: a thumbnail for
+// images, the head of text-like objects, and plain metadata for the rest.
+func (app *App) Preview(c *gin.Context) {
+	var req PreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Error("preview failed. failed to bind json", "err", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Bucket == "" || req.Key == "" {
+		c.JSON(400, gin.H{"error": "bucket and key are required"})
+		return
+	}
+
+	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
+	if bucketConfig == nil {
+		return
+	}
+
+	sse, err := customerKeyEncryption(*bucketConfig, req.SSECustomerKey)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	mio, err := Connect(*bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+
+	st, err := mio.StatObject(ctx, bucketConfig.BucketName, req.Key, minio.StatObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		if minio.ToErrorResponse(err).StatusCode == http.StatusBadRequest && sse == nil {
+			c.JSON(400, gin.H{"error": "object may be encrypted with SSE-C; provide sse_customer_key"})
+			return
+		}
+		slog.Error(err.Error())
+		c.JSON(404, gin.H{"error": "object not found"})
+		return
+	}
+
+	res := PreviewResponse{
+		Bucket:       req.Bucket,
+		Key:          req.Key,
+		Kind:         PreviewKindMetadata,
+		Size:         st.Size,
+		ContentType:  previewContentType(st.ContentType, req.Key),
+		ETag:         st.ETag,
+		LastModified: st.LastModified,
+	}
+
+	switch {
+	case isPreviewableImage(res.ContentType):
+		dim := clampInt(req.Size, previewDefaultDimension, previewMaxDimension)
+		img, err := app.imagePreview(ctx, mio, bucketConfig.BucketName, req.Bucket, st, sse, dim)
+		if err != nil {
+			// Fall back to metadata; a broken image shouldn't fail the request.
+			slog.Error("failed to build image preview", "key", req.Key, "err", err)
+			break
+		}
+		res.Kind = PreviewKindImage
+		res.Image = img
+
+	case isPreviewableText(res.ContentType):
+		limit := clampInt(req.MaxBytes, previewDefaultTextBytes, previewMaxTextBytes)
+		txt, err := textPreview(ctx, mio, bucketConfig.BucketName, st, sse, limit, res.ContentType)
+		if err != nil {
+			slog.Error("failed to build text preview", "key", req.Key, "err", err)
+			break
+		}
+		res.Kind = PreviewKindText
+		res.Text = txt
+	}
+
+	c.JSON(200, res)
+}
+
+// imagePreview builds a thumbnail, cached by ETag. Thumbnails of SSE-C
+// objects are never cached, since the cache isn't encrypted with their key.
+func (app *App) imagePreview(ctx context.Context, mio *minio.Client, bucketName, bucketID string, st minio.ObjectInfo, sse encrypt.ServerSide, dim int) (*PreviewImage, error) {
+	cacheKey := previewCacheKey(bucketID, st.Key, st.ETag, dim)
+	cache := sse == nil
+
+	if cached, err := storage.Get(app.DB, cacheKey); cache && err == nil {
+		var img PreviewImage
+		if err := json.Unmarshal(cached, &img); err == nil {
+			img.Cached = true
+			return &img, nil
+		}
+	}
+
+	if st.Size > previewMaxImageBytes {
+		return nil, fmt.Errorf("image too large to preview (%d bytes)", st.Size)
+	}
+
+	obj, err := mio.GetObject(ctx, bucketName, st.Key, minio.GetObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(io.LimitReader(obj, previewMaxImageBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	// Check dimensions before decoding so a tiny file can't allocate gigabytes.
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > previewMaxImagePixels {
+		return nil, fmt.Errorf("image dimensions too large (%dx%d)", cfg.Width, cfg.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	thumb := thumbnail(src, dim)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, thumb); err != nil {
+		return nil, err
+	}
+
+	img := &PreviewImage{
+		Format:       format,
+		Width:        cfg.Width,
+		Height:       cfg.Height,
+		ThumbWidth:   thumb.Bounds().Dx(),
+		ThumbHeight:  thumb.Bounds().Dy(),
+		ThumbnailPNG: buf.Bytes(),
+	}
+
+	if b, err := json.Marshal(img); cache && err == nil {
+		if err := storage.PutWithTTL(app.DB, cacheKey, b, previewCacheTTL); err != nil {
+			slog.Error("failed to cache thumbnail", "key", st.Key, "err", err)
+		}
+	}
+
+	return img, nil
+}
+
+// thumbnail scales src down to fit a dim x dim box using a box filter.
+// Images that already fit are returned as-is.
+func thumbnail(src image.Image, dim int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= dim && h <= dim {
+		return src
+	}
+
+	tw, th := dim, dim
+	if w > h {
+		th = max(1, h*dim/w)
+	} else {
+		tw = max(1, w*dim/h)
+	}
+
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, src, b.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, max((y+1)*h/th, y*h/th+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, max((x+1)*w/tw, x*w/tw+1)
+
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					p := rgba.RGBAAt(b.Min.X+sx, b.Min.Y+sy)
+					r += uint32(p.R)
+					g += uint32(p.G)
+					bl += uint32(p.B)
+					a += uint32(p.A)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: uint8(a / n)})
+		}
+	}
+	return dst
+}
+
+func textPreview(ctx context.Context, mio *minio.Client, bucketName string, st minio.ObjectInfo, sse encrypt.ServerSide, limit int, contentType string) (*PreviewText, error) {
+	opts := minio.GetObjectOptions{ServerSideEncryption: sse}
+	if st.Size > int64(limit) {
+		if err := opts.SetRange(0, int64(limit)-1); err != nil {
+			return nil, err
+		}
+	}
+
+	obj, err := mio.GetObject(ctx, bucketName, st.Key, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(io.LimitReader(obj, int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	truncated := st.Size > int64(len(data))
+	encoding, content := decodeText(data, truncated)
+
+	txt := &PreviewText{
+		Encoding:  encoding,
+		Content:   content,
+		Truncated: truncated,
+	}
+
+	switch {
+	case isCSV(contentType, st.Key):
+		txt.Format = "csv"
+		txt.Rows = csvRows(content, truncated)
+	case isJSON(contentType, st.Key):
+		txt.Format = "json"
+		if !truncated {
+			valid := json.Valid([]byte(content))
+			txt.ValidJSON = &valid
+			var out bytes.Buffer
+			if valid && json.Indent(&out, []byte(content), "", "  ") == nil {
+				txt.Content = out.String()
+			}
+		}
+	}
+
+	return txt, nil
+}
+
+// decodeText detects the encoding of data (BOM, then UTF-8 validity, falling
+// back to ISO-8859-1) and returns it as a Go string.
+func decodeText(data []byte, truncated bool) (string, string) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8", string(trimPartialRune(data[3:], truncated))
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return "utf-16le", decodeUTF16(data[2:], false)
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return "utf-16be", decodeUTF16(data[2:], true)
+	}
+
+	if d := trimPartialRune(data, truncated); utf8.Valid(d) {
+		return "utf-8", string(d)
+	}
+
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return "iso-8859-1", string(runes)
+}
+
+// trimPartialRune drops a multi-byte sequence cut in half by the range read.
+func trimPartialRune(data []byte, truncated bool) []byte {
+	if !truncated {
+		return data
+	}
+	for i := 1; i <= utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return data[:len(data)-i]
+			}
+			break
+		}
+	}
+	return data
+}
+
+func decodeUTF16(data []byte, bigEndian bool) string {
+	u := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		if bigEndian {
+			u = append(u, uint16(data[i])<<8|uint16(data[i+1]))
+		} else {
+			u = append(u, uint16(data[i+1])<<8|uint16(data[i]))
+		}
+	}
+	return string(utf16.Decode(u))
+}
+
+func csvRows(content string, truncated bool) [][]string {
+	r := csv.NewReader(strings.NewReader(content))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	rows := make([][]string, 0)
+	for len(rows) < previewMaxCSVRows {
+		rec, err := r.Read()
+		if err != nil {
+			break
+		}
+		rows = append(rows, rec)
+	}
+
+	// The last row of a truncated preview is probably incomplete.
+	if truncated && len(rows) > 1 && len(rows) < previewMaxCSVRows {
+		rows = rows[:len(rows)-1]
+	}
+	return rows
+}
+
+func previewContentType(stored, key string) string {
+	ct := strings.TrimSpace(stored)
+	if ct == "" || ct == OctetStream || ct == "binary/octet-stream" {
+		if byExt := mime.TypeByExtension(path.Ext(key)); byExt != "" {
+			return byExt
+		}
+		return OctetStream
+	}
+	return ct
+}
+
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(contentType)
+	}
+	return mt
+}
+
+func isPreviewableImage(contentType string) bool {
+	switch mediaType(contentType) {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+func isPreviewableText(contentType string) bool {
+	mt := mediaType(contentType)
+	if strings.HasPrefix(mt, "text/") || strings.HasSuffix(mt, "+json") || strings.HasSuffix(mt, "+xml") {
+		return true
+	}
+	switch mt {
+	case "application/json", "application/xml", "application/x-yaml", "application/yaml",
+		"application/javascript", "application/x-sh", "application/csv":
+		return true
+	}
+	return false
+}
+
+func isCSV(contentType, key string) bool {
+	mt := mediaType(contentType)
+	return mt == "text/csv" || mt == "application/csv" || strings.EqualFold(path.Ext(key), ".csv")
+}
+
+func isJSON(contentType, key string) bool {
+	mt := mediaType(contentType)
+	return mt == "application/json" || strings.HasSuffix(mt, "+json") || strings.EqualFold(path.Ext(key), ".json")
+}
+
+func previewCacheKey(bucketID, key, etag string, dim int) string {
+	return fmt.Sprintf("%s%s/%s/%d/%s", PreviewCachePrefix, bucketID, strings.Trim(etag, `"`), dim, key)
+}
+
+func clampInt(v, def, limit int) int {
+	if v <= 0 {
+		return def
+	}
+	if v > limit {
+		return limit
+	}
+	return v
+}
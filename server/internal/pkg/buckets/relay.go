@@ -0,0 +1,116 @@
+package buckets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"b0k3ts/internal/pkg/storage"
+)
+
+// With several replicas, an upload through one replica must reach event
+// streams held open by the others. Each replica writes its events to the
+// shared store with a short TTL and polls for the ones written elsewhere.
+const (
+	eventRelayTTL      = time.Minute
+	eventRelayInterval = time.Second
+)
+
+type eventRelay struct {
+	db      storage.Store
+	replica string
+	seq     atomic.Uint64
+	seen    map[string]time.Time // keys already polled, forgotten after the TTL
+}
+
+type relayedEvent struct {
+	Replica string      `json:"replica"`
+	Event   ObjectEvent `json:"event"`
+}
+
+// StartEventRelay shares object events with the other replicas until ctx is
+// done. Only needed with ha.enabled; a single replica delivers locally.
+func StartEventRelay(ctx context.Context, db storage.Store) error {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	r := &eventRelay{db: db, replica: hex.EncodeToString(id), seen: map[string]time.Time{}}
+
+	// Events already in the store were delivered before this replica started.
+	if _, err := r.poll(); err != nil {
+		return err
+	}
+	objectEvents.relay.Store(r)
+
+	go r.run(ctx)
+	return nil
+}
+
+func (r *eventRelay) run(ctx context.Context) {
+	t := time.NewTicker(eventRelayInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			events, err := r.poll()
+			if err != nil {
+				slog.Warn("failed to poll relayed object events", "err", err)
+				continue
+			}
+			for _, e := range events {
+				objectEvents.deliver(e)
+			}
+		}
+	}
+}
+
+// put stores an event published on this replica for the others.
+func (r *eventRelay) put(e ObjectEvent) {
+	raw, err := json.Marshal(relayedEvent{Replica: r.replica, Event: e})
+	if err != nil {
+		slog.Warn("failed to encode object event for relay", "err", err)
+		return
+	}
+	key := fmt.Sprintf("%s%020d-%s-%d", storage.NSEvents, e.Time.UnixNano(), r.replica, r.seq.Add(1))
+	if err := storage.PutWithTTL(r.db, key, raw, eventRelayTTL); err != nil {
+		slog.Warn("failed to relay object event", "bucket", e.Bucket, "key", e.Key, "err", err)
+	}
+}
+
+// poll returns the events other replicas stored since the last poll. Seen
+// keys are remembered rather than a high-water mark, so clock skew between
+// replicas can't hide an event.
+func (r *eventRelay) poll() ([]ObjectEvent, error) {
+	now := time.Now()
+	var out []ObjectEvent
+	err := r.db.View(func(tx storage.Tx) error {
+		return tx.Scan(storage.NSEvents, storage.ScanOptions{}, func(item storage.Item) error {
+			if _, ok := r.seen[item.Key]; ok {
+				return nil
+			}
+			r.seen[item.Key] = now
+
+			var re relayedEvent
+			if err := json.Unmarshal(item.Value, &re); err != nil || re.Replica == r.replica {
+				return nil
+			}
+			out = append(out, re.Event)
+			return nil
+		})
+	})
+
+	for k, at := range r.seen {
+		if now.Sub(at) > 2*eventRelayTTL {
+			delete(r.seen, k)
+		}
+	}
+	return out, err
+}
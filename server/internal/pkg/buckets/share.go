@@ -0,0 +1,429 @@
+package buckets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"b0k3ts/internal/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const ShareLinkPrefix = storage.NSShares
+
+const (
+	shareDefaultExpiry = 7 * 24 * time.Hour
+	shareMaxExpiry     = 365 * 24 * time.Hour
+	shareMaxListing    = 1000
+
+	shareContentSecurityPolicy = "default-src 'none'; sandbox"
+
+	// SharePasswordHeader carries the share password; a "password" form field works too.
+	SharePasswordHeader = "X-Share-Password"
+)
+
+var (
+	ErrShareNotFound         = errors.New("share link not found")
+	ErrShareExpired          = errors.New("share link expired")
+	ErrShareRevoked          = errors.New("share link revoked")
+	ErrShareDownloadsReached = errors.New("share link download limit reached")
+	ErrSharePassword         = errors.New("share link password required or invalid")
+)
+
+// ShareLink is the stored record. PasswordHash never leaves the server; use
+// toView for responses.
+type ShareLink struct {
+	Token        string    `json:"token"`
+	Bucket       string    `json:"bucket"`           // bucket connection id
+	Key          string    `json:"key,omitempty"`    // single object share
+	Prefix       string    `json:"prefix,omitempty"` // "folder" share
+	CreatedBy    string    `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	PasswordHash string    `json:"password_hash,omitempty"` // bcrypt
+	MaxDownloads int       `json:"max_downloads,omitempty"` // 0 = unlimited
+	Downloads    int       `json:"downloads"`
+	Revoked      bool      `json:"revoked"`
+}
+
+type ShareLinkView struct {
+	Token        string    `json:"token"`
+	URL          string    `json:"url"`
+	Bucket       string    `json:"bucket"`
+	Key          string    `json:"key,omitempty"`
+	Prefix       string    `json:"prefix,omitempty"`
+	CreatedBy    string    `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	HasPassword  bool      `json:"has_password"`
+	MaxDownloads int       `json:"max_downloads,omitempty"`
+	Downloads    int       `json:"downloads"`
+	Revoked      bool      `json:"revoked"`
+	Expired      bool      `json:"expired"`
+}
+
+type ShareCreateRequest struct {
+	Bucket         string `json:"bucket"`
+	Key            string `json:"key,omitempty"`             // either key ...
+	Prefix         string `json:"prefix,omitempty"`          // ... or prefix
+	ExpiresSeconds int64  `json:"expires_seconds,omitempty"` // optional; default 7 days, max 365 days
+	Password       string `json:"password,omitempty"`        // optional
+	MaxDownloads   int    `json:"max_downloads,omitempty"`   // optional; 0 = unlimited
+}
+
+type ShareRevokeRequest struct {
+	Token string `json:"token"`
+}
+
+type ShareListing struct {
+	Prefix  string   `json:"prefix"`
+	Objects []Object `json:"objects"` // keys relative to the shared prefix
+}
+
+func (s ShareLink) toView() ShareLinkView {
+	return ShareLinkView{
+		Token:        s.Token,
+		URL:          "/s/" + s.Token,
+		Bucket:       s.Bucket,
+		Key:          s.Key,
+		Prefix:       s.Prefix,
+		CreatedBy:    s.CreatedBy,
+		CreatedAt:    s.CreatedAt,
+		ExpiresAt:    s.ExpiresAt,
+		HasPassword:  s.PasswordHash != "",
+		MaxDownloads: s.MaxDownloads,
+		Downloads:    s.Downloads,
+		Revoked:      s.Revoked,
+		Expired:      time.Now().After(s.ExpiresAt),
+	}
+}
+
+func (app *App) CreateShare(c *gin.Context) {
+	var req ShareCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Error("create share failed. failed to bind json", "err", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Bucket == "" || (req.Key == "") == (req.Prefix == "") {
+		c.JSON(400, gin.H{"error": "bucket and exactly one of key or prefix are required"})
+		return
+	}
+	if req.MaxDownloads < 0 {
+		c.JSON(400, gin.H{"error": "max_downloads must not be negative"})
+		return
+	}
+
+	expires := time.Duration(req.ExpiresSeconds) * time.Second
+	if expires <= 0 {
+		expires = shareDefaultExpiry
+	}
+	if expires > shareMaxExpiry {
+		c.JSON(400, gin.H{"error": "expires_seconds too large"})
+		return
+	}
+
+	userInfo, ok := tokenUserOrRespond(c)
+	if !ok {
+		return
+	}
+
+	bucketConfig := authorizeAndExtract(*app, c, req.Bucket)
+	if bucketConfig == nil {
+		return
+	}
+	if bucketConfig.PerUser() {
+		respondError(c, errPerUser("share links"))
+		return
+	}
+
+	if req.Key != "" {
+		mio, err := Connect(*bucketConfig)
+		if err != nil {
+			slog.Error(err.Error())
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := mio.StatObject(context.Background(), bucketConfig.BucketName, req.Key, minio.StatObjectOptions{}); err != nil {
+			c.JSON(404, gin.H{"error": "object not found"})
+			return
+		}
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now().UTC()
+	link := ShareLink{
+		Token:        token,
+		Bucket:       req.Bucket,
+		Key:          req.Key,
+		Prefix:       normalizePrefix(req.Prefix),
+		CreatedBy:    userInfo.Email,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(expires),
+		MaxDownloads: req.MaxDownloads,
+	}
+
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			slog.Error(err.Error())
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		link.PasswordHash = string(hash)
+	}
+
+	if err := app.putShareLink(link); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, link.toView())
+}
+
+// ListShares returns the caller's links. Administrators may pass all=true to
+// see every link.
+func (app *App) ListShares(c *gin.Context) {
+	userInfo, ok := tokenUserOrRespond(c)
+	if !ok {
+		return
+	}
+	all := userInfo.Administrator && c.Query("all") == "true"
+
+	stored, err := shareRepo(app.DB).List()
+	if err != nil {
+		slog.Error("failed to list share links", "err", err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	links := make([]ShareLinkView, 0)
+	for _, link := range stored {
+		if all || link.CreatedBy == userInfo.Email {
+			links = append(links, link.toView())
+		}
+	}
+
+	sort.Slice(links, func(i, j int) bool { return links[i].CreatedAt.After(links[j].CreatedAt) })
+
+	c.JSON(200, links)
+}
+
+func (app *App) RevokeShare(c *gin.Context) {
+	userInfo, ok := tokenUserOrRespond(c)
+	if !ok {
+		return
+	}
+
+	var req ShareRevokeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(400, gin.H{"error": "token is required"})
+		return
+	}
+
+	link, err := app.getShareLink(req.Token)
+	if err != nil {
+		c.JSON(404, gin.H{"error": ErrShareNotFound.Error()})
+		return
+	}
+	if link.CreatedBy != userInfo.Email && !userInfo.Administrator {
+		c.JSON(403, gin.H{"error": "not allowed"})
+		return
+	}
+
+	link.Revoked = true
+	if err := app.putShareLink(*link); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "share link revoked"})
+}
+
+// ServeShare is the unauthenticated entry point for share links. Object
+// shares stream the object; prefix shares list their contents, or stream the
+// object named by ?key= (relative to the shared prefix).
+func (app *App) ServeShare(c *gin.Context) {
+	token := c.Param("token")
+
+	link, err := app.getShareLink(token)
+	if err != nil {
+		c.JSON(404, gin.H{"error": ErrShareNotFound.Error()})
+		return
+	}
+	if err := checkShareLink(link, sharePassword(c)); err != nil {
+		respondShareError(c, err)
+		return
+	}
+
+	bucketConfig, ok := getBucketConfigOrRespond(c, app.DB, link.Bucket)
+	if !ok {
+		return
+	}
+
+	mio, err := Connect(bucketConfig)
+	if err != nil {
+		slog.Error(err.Error())
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	key := link.Key
+	if link.Prefix != "" {
+		rel := strings.TrimPrefix(c.Query("key"), "/")
+		if rel == "" {
+			listShare(c, mio, bucketConfig.BucketName, link.Prefix)
+			return
+		}
+		clean, err := sanitizeEntryName(rel)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		key = link.Prefix + clean
+	}
+
+	// Only count a download that will be served: a bad key or a deleted
+	// object mustn't use up a limited link.
+	if _, err := mio.StatObject(context.Background(), bucketConfig.BucketName, key, minio.StatObjectOptions{}); err != nil {
+		slog.Error(err.Error())
+		c.JSON(404, gin.H{"error": "object not found"})
+		return
+	}
+
+	if err := app.consumeShareDownload(token, sharePassword(c)); err != nil {
+		respondShareError(c, err)
+		return
+	}
+
+	// Anyone can upload HTML or SVG, and this is the app's own origin: never
+	// let the browser render it.
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", shareContentSecurityPolicy)
+	streamObject(c, mio, bucketConfig.BucketName, key, "attachment", minio.GetObjectOptions{})
+}
+
+func listShare(c *gin.Context, mio *minio.Client, bucketName, prefix string) {
+	ch := mio.ListObjects(context.Background(), bucketName, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	})
+
+	objects := make([]Object, 0)
+	for obj := range ch {
+		if obj.Err != nil {
+			slog.Error(obj.Err.Error())
+			c.JSON(400, gin.H{"error": obj.Err.Error()})
+			return
+		}
+		if len(objects) >= shareMaxListing {
+			break
+		}
+		objects = append(objects, Object{Key: strings.TrimPrefix(obj.Key, prefix), Size: obj.Size, ContentType: obj.ContentType})
+	}
+
+	c.JSON(200, ShareListing{Prefix: prefix, Objects: objects})
+}
+
+func checkShareLink(link *ShareLink, password string) error {
+	if link.Revoked {
+		return ErrShareRevoked
+	}
+	if time.Now().After(link.ExpiresAt) {
+		return ErrShareExpired
+	}
+	if link.MaxDownloads > 0 && link.Downloads >= link.MaxDownloads {
+		return ErrShareDownloadsReached
+	}
+	if link.PasswordHash != "" {
+		if password == "" || bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			return ErrSharePassword
+		}
+	}
+	return nil
+}
+
+// consumeShareDownload re-checks the link and bumps its download counter in a
+// single transaction so concurrent requests can't exceed MaxDownloads.
+func (app *App) consumeShareDownload(token, password string) error {
+	return app.DB.Update(func(tx storage.Tx) error {
+		links := shareRepo(app.DB).Tx(tx)
+		link, err := links.Get(token)
+		if err != nil {
+			if storage.IsNotFound(err) {
+				return ErrShareNotFound
+			}
+			return err
+		}
+		if err := checkShareLink(&link, password); err != nil {
+			return err
+		}
+
+		link.Downloads++
+		return links.Put(token, link)
+	})
+}
+
+func sharePassword(c *gin.Context) string {
+	if p := c.GetHeader(SharePasswordHeader); p != "" {
+		return p
+	}
+	return c.PostForm("password")
+}
+
+func respondShareError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrShareNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrSharePassword):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "password_required": true})
+	case errors.Is(err, ErrShareExpired), errors.Is(err, ErrShareRevoked), errors.Is(err, ErrShareDownloadsReached):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	default:
+		slog.Error(err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func newShareToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// shareRepo stores share links keyed by token.
+func shareRepo(db storage.Store) storage.Repository[ShareLink] {
+	return storage.NewRepository[ShareLink](db, ShareLinkPrefix)
+}
+
+func (app *App) putShareLink(link ShareLink) error {
+	return shareRepo(app.DB).Put(link.Token, link)
+}
+
+func (app *App) getShareLink(token string) (*ShareLink, error) {
+	if strings.TrimSpace(token) == "" {
+		return nil, ErrShareNotFound
+	}
+	link, err := shareRepo(app.DB).Get(token)
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
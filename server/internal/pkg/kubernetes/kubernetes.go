@@ -1,6 +1,7 @@
 package kubernetes
 
 import (
+	"b0k3ts/internal/pkg/storage"
 	"context"
 	"encoding/json"
 	"errors"
@@ -9,13 +10,10 @@ import (
 	"log/slog"
 	"net/http"
 	"regexp"
-	"sort"
+	"slices"
 	"strings"
 	"time"
 
-	badgerKV "b0k3ts/internal/pkg/badger"
-
-	"github.com/dgraph-io/badger/v4"
 	"github.com/gin-gonic/gin"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -32,7 +30,7 @@ import (
 // --- Constants / Types ---
 
 const (
-	kubeconfigKeyPrefix = "kubeconfig-"
+	KubeconfigKeyPrefix = storage.NSKubeconfigs
 
 	defaultFieldManager = "b0k3ts"
 
@@ -76,7 +74,7 @@ type BucketListRequest struct {
 
 // RegisterRoutes mounts Kubernetes-related APIs under the provided router group.
 // Recommended mount point: /api/v1/kubernetes
-func RegisterRoutes(rg *gin.RouterGroup, db *badger.DB) {
+func RegisterRoutes(rg *gin.RouterGroup, db storage.Store) {
 	h := &handler{db: db}
 
 	// Kubeconfigs (named)
@@ -94,14 +92,10 @@ func RegisterRoutes(rg *gin.RouterGroup, db *badger.DB) {
 }
 
 type handler struct {
-	db *badger.DB
+	db storage.Store
 }
 
-// --- Kubeconfig store (Badger) ---
-
-func kubeconfigKey(name string) string {
-	return kubeconfigKeyPrefix + name
-}
+// --- Kubeconfig store ---
 
 func ValidateKubeconfigName(name string) error {
 	name = strings.TrimSpace(name)
@@ -114,9 +108,9 @@ func ValidateKubeconfigName(name string) error {
 	return nil
 }
 
-func SaveKubeconfig(db *badger.DB, name string, kubeconfigBytes []byte) error {
+func SaveKubeconfig(db storage.Store, name string, kubeconfigBytes []byte) error {
 	if db == nil {
-		return errors.New("save kubeconfig failed. badger db is nil")
+		return errors.New("save kubeconfig failed. storage is nil")
 	}
 	if err := ValidateKubeconfigName(name); err != nil {
 		return err
@@ -130,7 +124,7 @@ func SaveKubeconfig(db *badger.DB, name string, kubeconfigBytes []byte) error {
 		return fmt.Errorf("invalid kubeconfig: %w", err)
 	}
 
-	if err := badgerKV.PutKV(db, kubeconfigKey(name), kubeconfigBytes); err != nil {
+	if err := kubeconfigs(db).Put(name, kubeconfigBytes); err != nil {
 		return err
 	}
 
@@ -138,56 +132,41 @@ func SaveKubeconfig(db *badger.DB, name string, kubeconfigBytes []byte) error {
 	return nil
 }
 
-func GetKubeconfig(db *badger.DB, name string) ([]byte, error) {
+func GetKubeconfig(db storage.Store, name string) ([]byte, error) {
 	if db == nil {
-		return nil, errors.New("get kubeconfig failed. badger db is nil")
+		return nil, errors.New("get kubeconfig failed. storage is nil")
 	}
 	if err := ValidateKubeconfigName(name); err != nil {
 		return nil, err
 	}
-	return badgerKV.PullKV(db, kubeconfigKey(name))
+	return kubeconfigs(db).Get(name)
 }
 
-func DeleteKubeconfig(db *badger.DB, name string) error {
+func DeleteKubeconfig(db storage.Store, name string) error {
 	if db == nil {
-		return errors.New("delete kubeconfig failed. badger db is nil")
+		return errors.New("delete kubeconfig failed. storage is nil")
 	}
 	if err := ValidateKubeconfigName(name); err != nil {
 		return err
 	}
-	return badgerKV.DeleteKV(db, kubeconfigKey(name))
+	return kubeconfigs(db).Delete(name)
 }
 
-func ListKubeconfigNames(db *badger.DB) ([]string, error) {
+func ListKubeconfigNames(db storage.Store) ([]string, error) {
 	if db == nil {
-		return nil, errors.New("list kubeconfig failed. badger db is nil")
+		return nil, errors.New("list kubeconfig failed. storage is nil")
 	}
 
-	var names []string
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		opts.Prefix = []byte(kubeconfigKeyPrefix)
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		prefix := []byte(kubeconfigKeyPrefix)
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			key := string(it.Item().Key())
-			name := strings.TrimPrefix(key, kubeconfigKeyPrefix)
-			if name != "" {
-				names = append(names, name)
-			}
-		}
-		return nil
-	})
+	names, err := kubeconfigs(db).IDs()
 	if err != nil {
 		return nil, err
 	}
+	return slices.DeleteFunc(names, func(n string) bool { return n == "" }), nil
+}
 
-	sort.Strings(names)
-	return names, nil
+// kubeconfigs stores raw kubeconfig files keyed by name.
+func kubeconfigs(db storage.Store) storage.Repository[[]byte] {
+	return storage.NewRepository[[]byte](db, KubeconfigKeyPrefix)
 }
 
 // --- REST configs & clients (client-go) ---
@@ -201,7 +180,7 @@ func BuildRestConfigInCluster() (*rest.Config, error) {
 	return cfg, nil
 }
 
-func BuildRestConfigFromBadger(ctx context.Context, db *badger.DB, name string) (*rest.Config, error) {
+func BuildRestConfigFromStore(ctx context.Context, db storage.Store, name string) (*rest.Config, error) {
 	_ = ctx // reserved for future (e.g., external decrypt, audit)
 	kc, err := GetKubeconfig(db, name)
 	if err != nil {
@@ -265,7 +244,7 @@ func (h *handler) buildClientsFromRequest(c *gin.Context) (dynamic.Interface, *k
 		if name == "" {
 			return nil, nil, fmt.Errorf("missing query parameter %q for mode=%q", queryConfig, modeKubeconfig)
 		}
-		cfg, err = BuildRestConfigFromBadger(c.Request.Context(), h.db, name)
+		cfg, err = BuildRestConfigFromStore(c.Request.Context(), h.db, name)
 	default:
 		return nil, nil, fmt.Errorf("unsupported mode %q (use %q or %q)", mode, modeInCluster, modeKubeconfig)
 	}
@@ -421,7 +400,7 @@ func GetBucketSecretCreds(ctx context.Context, core *kubernetes.Clientset, names
 
 // --- Gin handlers ---
 
-// UploadKubeconfig stores a kubeconfig in Badger under a *named* key.
+// UploadKubeconfig stores a kubeconfig under a *named* key.
 // Supports either:
 // - multipart/form-data with field "file"
 // - raw body (application/yaml, text/yaml, application/octet-stream, etc.)
@@ -0,0 +1,263 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"b0k3ts/configs"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// --- Constants / Types ---
+
+const (
+	defaultLeaseName     = "b0k3ts-leader"
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewDeadline = 10 * time.Second
+	defaultRetryPeriod   = 2 * time.Second
+
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// Elector decides which replica runs background work. Without HA the only
+// replica is always the leader. With HA the holder of a Kubernetes Lease is
+// the leader; its tasks are cancelled if the lease is lost and started again
+// if it's won back.
+type Elector struct {
+	cfg      configs.HAConfig
+	lock     *resourcelock.LeaseLock // nil without HA
+	identity string
+
+	mu      sync.Mutex
+	tasks   []task
+	term    context.Context // set while leading
+	endTerm context.CancelFunc
+
+	running sync.WaitGroup // tasks and the election loop
+}
+
+type task struct {
+	name string
+	fn   func(ctx context.Context)
+}
+
+func New(cfg configs.HAConfig) (*Elector, error) {
+	if !cfg.Enabled {
+		return &Elector{cfg: cfg}, nil
+	}
+
+	if cfg.LeaseName == "" {
+		cfg.LeaseName = defaultLeaseName
+	}
+	if cfg.LeaseDuration <= 0 {
+		cfg.LeaseDuration = defaultLeaseDuration
+	}
+	if cfg.RenewDeadline <= 0 {
+		cfg.RenewDeadline = defaultRenewDeadline
+	}
+	if cfg.RetryPeriod <= 0 {
+		cfg.RetryPeriod = defaultRetryPeriod
+	}
+	if cfg.LeaseDuration <= cfg.RenewDeadline || cfg.RenewDeadline <= cfg.RetryPeriod {
+		return nil, errors.New("ha: leaseDuration must be greater than renewDeadline, and renewDeadline greater than retryPeriod")
+	}
+
+	namespace, err := leaseNamespace(cfg)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := leaseIdentity(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	restCfg, err := restConfig()
+	if err != nil {
+		return nil, fmt.Errorf("ha: kubernetes client: %w", err)
+	}
+	cs, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		return nil, fmt.Errorf("ha: kubernetes client: %w", err)
+	}
+
+	return &Elector{
+		cfg:      cfg,
+		identity: identity,
+		lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Name: cfg.LeaseName, Namespace: namespace},
+			Client:     cs.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+	}, nil
+}
+
+// --- Public ---
+
+// Go registers background work that only the leader runs. fn gets a context
+// that is cancelled when leadership ends; it may return early or block until
+// then. Tasks registered while leading start at once.
+func (e *Elector) Go(name string, fn func(ctx context.Context)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	t := task{name: name, fn: fn}
+	e.tasks = append(e.tasks, t)
+	if e.term != nil {
+		e.start(t)
+	}
+}
+
+// IsLeader reports whether this replica currently runs background work.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.term != nil
+}
+
+// Start begins leading (without HA) or contending for the lease until ctx is
+// done. Losing the lease stops the tasks; this replica then keeps serving the
+// API and contends again.
+func (e *Elector) Start(ctx context.Context) error {
+	if e.lock == nil {
+		e.beginTerm(ctx)
+		return nil
+	}
+
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            e.lock,
+		Name:            e.cfg.LeaseName,
+		LeaseDuration:   e.cfg.LeaseDuration,
+		RenewDeadline:   e.cfg.RenewDeadline,
+		RetryPeriod:     e.cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: e.beginTerm,
+			OnStoppedLeading: e.finishTerm,
+			OnNewLeader: func(identity string) {
+				slog.Info("ha leader elected", "leader", identity, "self", identity == e.identity)
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("ha: %w", err)
+	}
+
+	slog.Info("ha enabled, contending for leadership",
+		"lease", e.cfg.LeaseName, "namespace", e.lock.LeaseMeta.Namespace, "identity", e.identity)
+
+	e.running.Add(1)
+	go func() {
+		defer e.running.Done()
+		for ctx.Err() == nil {
+			le.Run(ctx) // returns when leadership is lost or ctx is done
+		}
+	}()
+	return nil
+}
+
+// Wait blocks until, after the ctx given to Start is done, every task has
+// returned and the lease has been released, or until waitCtx is done.
+func (e *Elector) Wait(waitCtx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		e.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-waitCtx.Done():
+		return waitCtx.Err()
+	}
+}
+
+// --- Terms ---
+
+func (e *Elector) beginTerm(ctx context.Context) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.term, e.endTerm = context.WithCancel(ctx)
+	if e.lock != nil {
+		slog.Info("ha leadership acquired, starting background work", "identity", e.identity, "tasks", len(e.tasks))
+	}
+	for _, t := range e.tasks {
+		e.start(t)
+	}
+}
+
+func (e *Elector) finishTerm() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.term == nil {
+		return
+	}
+	e.endTerm()
+	e.term, e.endTerm = nil, nil
+	slog.Warn("ha leadership lost, background work stopped", "identity", e.identity)
+}
+
+// start runs t for the current term; e.mu must be held.
+func (e *Elector) start(t task) {
+	ctx := e.term
+	e.running.Add(1)
+	go func() {
+		defer e.running.Done()
+		slog.Debug("background task started", "task", t.name)
+		t.fn(ctx)
+	}()
+}
+
+// --- Helpers ---
+
+func leaseNamespace(cfg configs.HAConfig) (string, error) {
+	if cfg.LeaseNamespace != "" {
+		return cfg.LeaseNamespace, nil
+	}
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns, nil
+	}
+	if b, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		if ns := strings.TrimSpace(string(b)); ns != "" {
+			return ns, nil
+		}
+	}
+	return "", errors.New("ha: leaseNamespace is not set and POD_NAMESPACE is empty")
+}
+
+func leaseIdentity(cfg configs.HAConfig) (string, error) {
+	if cfg.Identity != "" {
+		return cfg.Identity, nil
+	}
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name, nil
+	}
+	return os.Hostname()
+}
+
+// restConfig uses the pod's service account, or the local kubeconfig when
+// running outside a cluster.
+func restConfig() (*rest.Config, error) {
+	cfg, err := rest.InClusterConfig()
+	if !errors.Is(err, rest.ErrNotInCluster) {
+		return cfg, err
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{},
+	).ClientConfig()
+}
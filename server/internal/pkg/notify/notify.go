@@ -0,0 +1,180 @@
+package notify
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"b0k3ts/internal/pkg/auth"
+	"b0k3ts/internal/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Constants / Types ---
+
+const (
+	notificationKeyPrefix = storage.NSNotifications
+
+	// Notifications older than this are dropped by the store on its own.
+	notificationTTL = 30 * 24 * time.Hour
+)
+
+type Notification struct {
+	Id        string            `json:"id"`
+	Recipient string            `json:"recipient"` // user email
+	Kind      string            `json:"kind"`      // e.g. "dropbox_upload"
+	Message   string            `json:"message"`
+	Data      map[string]string `json:"data,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	Read      bool              `json:"read"`
+}
+
+type markReadRequest struct {
+	Id string `json:"id"`
+}
+
+// --- Public: Route registration ---
+
+// RegisterRoutes mounts the notification APIs for the calling user.
+// Recommended mount point: /api/v1/notifications
+func RegisterRoutes(rg *gin.RouterGroup, db storage.Store) {
+	h := &handler{db: db}
+
+	rg.GET("/list", h.List)
+	rg.POST("/read", h.MarkRead)
+}
+
+type handler struct {
+	db storage.Store
+}
+
+// --- Store ---
+
+func recipientPrefix(recipient string) string {
+	return notificationKeyPrefix + strings.ToLower(strings.TrimSpace(recipient)) + "/"
+}
+
+// Notify records a notification for recipient. Failures are returned but
+// callers usually just log them: a missed notification shouldn't fail the
+// action that caused it.
+func Notify(db storage.Store, recipient, kind, message string, data map[string]string) error {
+	if db == nil {
+		return errors.New("notify failed. storage is nil")
+	}
+	if strings.TrimSpace(recipient) == "" {
+		return errors.New("notification recipient is required")
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	n := Notification{
+		// Time-ordered ids keep keys sorted in creation order.
+		Id:        fmt.Sprintf("%020d-%s", now.UnixNano(), hex.EncodeToString(b)),
+		Recipient: recipient,
+		Kind:      kind,
+		Message:   message,
+		Data:      data,
+		CreatedAt: now,
+	}
+
+	raw, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("notification", "recipient", recipient, "kind", kind, "message", message)
+	return storage.PutWithTTL(db, recipientPrefix(recipient)+n.Id, raw, notificationTTL)
+}
+
+func List(db storage.Store, recipient string) ([]Notification, error) {
+	out := make([]Notification, 0)
+
+	err := db.View(func(tx storage.Tx) error {
+		return tx.Scan(recipientPrefix(recipient), storage.ScanOptions{}, func(item storage.Item) error {
+			var n Notification
+			if err := json.Unmarshal(item.Value, &n); err != nil {
+				return err
+			}
+			out = append(out, n)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Id > out[j].Id })
+	return out, nil
+}
+
+func MarkRead(db storage.Store, recipient, id string) error {
+	key := recipientPrefix(recipient) + id
+
+	raw, err := storage.Get(db, key)
+	if err != nil {
+		return err
+	}
+
+	var n Notification
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return err
+	}
+	n.Read = true
+
+	raw, err = json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return storage.PutWithTTL(db, key, raw, notificationTTL)
+}
+
+// --- Gin handlers ---
+
+func (h *handler) List(c *gin.Context) {
+	userInfo, _ := auth.TokenToUserData(c.GetHeader("Authorization"))
+	if strings.TrimSpace(userInfo.Email) == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing user"})
+		return
+	}
+
+	items, err := List(h.db, userInfo.Email)
+	if err != nil {
+		slog.Error("failed to list notifications", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list notifications"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *handler) MarkRead(c *gin.Context) {
+	userInfo, _ := auth.TokenToUserData(c.GetHeader("Authorization"))
+	if strings.TrimSpace(userInfo.Email) == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing user"})
+		return
+	}
+
+	var req markReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Id) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id is required"})
+		return
+	}
+
+	if err := MarkRead(h.db, userInfo.Email, req.Id); err != nil {
+		slog.Error("failed to mark notification read", "err", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "notification not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "notification marked read"})
+}
@@ -0,0 +1,29 @@
+// Package seal derives AES-256-GCM ciphers from passphrases. State bundles
+// and backups are encrypted with it.
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+)
+
+const (
+	KDF        = "pbkdf2-sha256"
+	Iterations = 600_000
+	SaltSize   = 16
+)
+
+// NewGCM derives a 256-bit key from passphrase with PBKDF2-SHA256.
+func NewGCM(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,527 @@
+package state
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"b0k3ts/internal/pkg/audit"
+	"b0k3ts/internal/pkg/auth"
+	"b0k3ts/internal/pkg/buckets"
+	"b0k3ts/internal/pkg/kubernetes"
+	"b0k3ts/internal/pkg/seal"
+	"b0k3ts/internal/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// --- Constants / Types ---
+
+const (
+	BundleFormat       = "b0k3ts-state"
+	SealedBundleFormat = "b0k3ts-state-sealed"
+
+	// BundleVersion is bumped whenever the bundle layout changes. Older
+	// bundles stay importable; newer ones are refused.
+	// Version 2 uses the namespaced storage keys.
+	BundleVersion = 2
+
+	ModeMerge   = "merge"   // upsert bundle entries, keep everything else
+	ModeReplace = "replace" // make the bundle's sections match it exactly
+
+	// PassphraseEnv holds the passphrase for the export/import subcommands.
+	PassphraseEnv = "B0K3TS_STATE_PASSPHRASE"
+
+	maxImportBytes = 64 << 20
+)
+
+// section is a group of storage keys, matched by prefix or by an exact key.
+type section struct {
+	name   string
+	prefix string
+	key    string
+}
+
+// Only durable state is exported. Caches, jobs, share and upload links
+// (which expire), notifications and the audit trail stay behind.
+var sections = []section{
+	{name: "users", prefix: auth.UserKeyPrefix},
+	{name: "connections", prefix: buckets.BucketIdPrefix},
+	{name: "endpoints", prefix: buckets.EndpointIdPrefix},
+	{name: "oidc", key: auth.OIDCConfigVar},
+	{name: "kubeconfigs", prefix: kubernetes.KubeconfigKeyPrefix},
+	{name: "settings", key: storage.KeyServerConfig}, // the config.yaml loaded at last startup
+}
+
+// Bundle is a versioned snapshot of b0k3ts state.
+type Bundle struct {
+	Format    string             `json:"format"`
+	Version   int                `json:"version"`
+	CreatedAt time.Time          `json:"created_at"`
+	Sections  map[string][]Entry `json:"sections"`
+}
+
+// Entry is one storage key. JSON values are kept readable; anything else
+// (kubeconfig YAML, config.yaml) is base64 in data.
+type Entry struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Data  []byte          `json:"data,omitempty"`
+}
+
+// sealedBundle is a bundle encrypted with AES-256-GCM under a
+// PBKDF2-SHA256 key derived from a passphrase.
+type sealedBundle struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Change is one key an import creates, updates or deletes.
+type Change struct {
+	Section string `json:"section"`
+	Key     string `json:"key"`
+	Op      string `json:"op"` // create, update or delete
+}
+
+// Plan is what an import does (or, for a dry run, would do).
+type Plan struct {
+	Mode      string   `json:"mode"`
+	DryRun    bool     `json:"dry_run"`
+	Changes   []Change `json:"changes"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// --- Public: Route registration ---
+
+// RegisterRoutes mounts the admin-only export/import APIs.
+// Recommended mount point: /api/v1/state
+func RegisterRoutes(rg *gin.RouterGroup, db storage.Store) {
+	h := &handler{db: db}
+	rg.POST("/export", h.Export)
+	rg.POST("/import", h.Import)
+}
+
+type handler struct {
+	db storage.Store
+}
+
+// --- Export / Import ---
+
+// SectionNames lists the sections a bundle can hold, in export order.
+func SectionNames() []string {
+	out := make([]string, 0, len(sections))
+	for _, s := range sections {
+		out = append(out, s.name)
+	}
+	return out
+}
+
+// Export snapshots the given sections (all when empty).
+func Export(db storage.Store, names []string) (*Bundle, error) {
+	selected, err := selectSections(names)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bundle{
+		Format:    BundleFormat,
+		Version:   BundleVersion,
+		CreatedAt: time.Now().UTC(),
+		Sections:  map[string][]Entry{},
+	}
+	err = db.View(func(tx storage.Tx) error {
+		for _, s := range selected {
+			cur, err := readSection(tx, s)
+			if err != nil {
+				return err
+			}
+			entries := make([]Entry, 0, len(cur))
+			for _, k := range slices.Sorted(maps.Keys(cur)) {
+				entries = append(entries, newEntry(k, cur[k]))
+			}
+			b.Sections[s.name] = entries
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Import applies a bundle. Merge upserts its entries; replace also deletes
+// keys in the bundle's sections that it doesn't contain. With dryRun the
+// plan is computed but nothing is written.
+func Import(db storage.Store, b *Bundle, mode string, dryRun bool) (Plan, error) {
+	if mode == "" {
+		mode = ModeMerge
+	}
+	if mode != ModeMerge && mode != ModeReplace {
+		return Plan{}, fmt.Errorf("mode must be %s or %s", ModeMerge, ModeReplace)
+	}
+	if err := validateBundle(b); err != nil {
+		return Plan{}, err
+	}
+	upgradeBundle(b)
+
+	plan := Plan{Mode: mode, DryRun: dryRun, Changes: make([]Change, 0)}
+	apply := func(tx storage.Tx) error {
+		// Update may retry the transaction, so the plan starts over each time.
+		plan.Changes, plan.Unchanged = plan.Changes[:0], 0
+
+		for _, s := range sections {
+			entries, ok := b.Sections[s.name]
+			if !ok {
+				continue
+			}
+			cur, err := readSection(tx, s)
+			if err != nil {
+				return err
+			}
+
+			incoming := map[string]bool{}
+			for _, e := range entries {
+				if !s.matches(e.Key) {
+					return fmt.Errorf("%s: key %q doesn't belong to this section", s.name, e.Key)
+				}
+				incoming[e.Key] = true
+
+				val := e.bytes()
+				op := "create"
+				if old, ok := cur[e.Key]; ok {
+					if bytes.Equal(newEntry(e.Key, old).bytes(), val) {
+						plan.Unchanged++
+						continue
+					}
+					op = "update"
+				}
+				plan.Changes = append(plan.Changes, Change{Section: s.name, Key: e.Key, Op: op})
+				if !dryRun {
+					if err := tx.Set(e.Key, val); err != nil {
+						return err
+					}
+				}
+			}
+
+			if mode != ModeReplace {
+				continue
+			}
+			for _, k := range slices.Sorted(maps.Keys(cur)) {
+				if incoming[k] {
+					continue
+				}
+				plan.Changes = append(plan.Changes, Change{Section: s.name, Key: k, Op: "delete"})
+				if !dryRun {
+					if err := tx.Delete(k); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if dryRun {
+		err = db.View(apply)
+	} else {
+		err = db.Update(apply)
+	}
+	if err != nil {
+		return Plan{}, err
+	}
+
+	if !dryRun && len(plan.Changes) > 0 {
+		buckets.ResetClients()
+	}
+	return plan, nil
+}
+
+// Seal encodes a bundle, encrypting it when a passphrase is given.
+func Seal(b *Bundle, passphrase string) ([]byte, error) {
+	raw, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return raw, nil
+	}
+
+	salt := make([]byte, seal.SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := seal.NewGCM(passphrase, salt, seal.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(sealedBundle{
+		Format:     SealedBundleFormat,
+		Version:    BundleVersion,
+		KDF:        seal.KDF,
+		Iterations: seal.Iterations,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, raw, []byte(SealedBundleFormat)),
+	}, "", "  ")
+}
+
+// Open decodes a plain or sealed bundle.
+func Open(data []byte, passphrase string) (*Bundle, error) {
+	var head struct {
+		Format string `json:"format"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, errors.New("bundle is not valid JSON")
+	}
+
+	switch head.Format {
+	case BundleFormat:
+	case SealedBundleFormat:
+		if passphrase == "" {
+			return nil, errors.New("bundle is encrypted; a passphrase is required")
+		}
+		var sb sealedBundle
+		if err := json.Unmarshal(data, &sb); err != nil {
+			return nil, err
+		}
+		if sb.KDF != seal.KDF || sb.Iterations <= 0 || sb.Iterations > 10*seal.Iterations {
+			return nil, fmt.Errorf("unsupported key derivation %q", sb.KDF)
+		}
+		gcm, err := seal.NewGCM(passphrase, sb.Salt, sb.Iterations)
+		if err != nil {
+			return nil, err
+		}
+		if len(sb.Nonce) != gcm.NonceSize() {
+			return nil, errors.New("bundle nonce is invalid")
+		}
+		if data, err = gcm.Open(nil, sb.Nonce, sb.Ciphertext, []byte(SealedBundleFormat)); err != nil {
+			return nil, errors.New("wrong passphrase or corrupted bundle")
+		}
+	default:
+		return nil, fmt.Errorf("not a b0k3ts state bundle (format %q)", head.Format)
+	}
+
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, err
+	}
+	if err := validateBundle(&b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// --- Gin handlers ---
+
+type exportRequest struct {
+	Passphrase string   `json:"passphrase,omitempty"` // encrypts the bundle
+	Sections   []string `json:"sections,omitempty"`   // default: all
+}
+
+func (h *handler) Export(c *gin.Context) {
+	userInfo, ok := auth.VerifiedAdminOrRespond(c)
+	if !ok {
+		return
+	}
+
+	var req exportRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	b, err := Export(h.db, req.Sections)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	out, err := Seal(b, req.Passphrase)
+	if err != nil {
+		slog.Error("failed to seal state bundle", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export state"})
+		return
+	}
+
+	if err := audit.Record(h.db, userInfo.Email, "state_export", "", map[string]string{
+		"sections":  strings.Join(slices.Sorted(maps.Keys(b.Sections)), ","),
+		"encrypted": fmt.Sprint(req.Passphrase != ""),
+	}); err != nil {
+		slog.Error("failed to record state export audit event", "err", err)
+	}
+
+	filename := fmt.Sprintf("b0k3ts-state-%s.json", b.CreatedAt.Format("20060102-150405"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "application/json", out)
+}
+
+// Import takes a multipart form: file, and optional passphrase, mode
+// (merge or replace) and dry_run.
+func (h *handler) Import(c *gin.Context) {
+	userInfo, ok := auth.VerifiedAdminOrRespond(c)
+	if !ok {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	b, err := Open(data, c.PostForm("passphrase"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dryRun := c.PostForm("dry_run") == "true"
+	plan, err := Import(h.db, b, c.PostForm("mode"), dryRun)
+	if err != nil {
+		slog.Error("state import failed", "err", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !dryRun {
+		if err := audit.Record(h.db, userInfo.Email, "state_import", "", map[string]string{
+			"mode":    plan.Mode,
+			"changes": fmt.Sprint(len(plan.Changes)),
+		}); err != nil {
+			slog.Error("failed to record state import audit event", "err", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+// --- Helpers ---
+
+func (s section) matches(key string) bool {
+	if s.key != "" {
+		return key == s.key
+	}
+	return strings.HasPrefix(key, s.prefix)
+}
+
+func selectSections(names []string) ([]section, error) {
+	if len(names) == 0 {
+		return sections, nil
+	}
+	out := make([]section, 0, len(names))
+	for _, s := range sections {
+		if slices.Contains(names, s.name) {
+			out = append(out, s)
+		}
+	}
+	for _, n := range names {
+		if !slices.Contains(SectionNames(), n) {
+			return nil, fmt.Errorf("unknown section %q; want one of %s", n, strings.Join(SectionNames(), ", "))
+		}
+	}
+	return out, nil
+}
+
+func readSection(tx storage.Tx, s section) (map[string][]byte, error) {
+	out := map[string][]byte{}
+
+	if s.key != "" {
+		v, err := tx.Get(s.key)
+		if storage.IsNotFound(err) {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[s.key] = v
+		return out, nil
+	}
+
+	err := tx.Scan(s.prefix, storage.ScanOptions{}, func(item storage.Item) error {
+		out[item.Key] = item.Value
+		return nil
+	})
+	return out, err
+}
+
+func newEntry(key string, v []byte) Entry {
+	if json.Valid(v) {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, v); err == nil {
+			return Entry{Key: key, Value: buf.Bytes()}
+		}
+	}
+	return Entry{Key: key, Data: v}
+}
+
+func (e Entry) bytes() []byte {
+	if len(e.Value) > 0 {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, e.Value); err == nil {
+			return buf.Bytes()
+		}
+		return e.Value
+	}
+	return e.Data
+}
+
+// upgradeBundle rewrites a version 1 bundle's pre-namespace keys.
+func upgradeBundle(b *Bundle) {
+	if b.Version >= 2 {
+		return
+	}
+	for _, entries := range b.Sections {
+		for i := range entries {
+			entries[i].Key = storage.MigrateKey(entries[i].Key)
+		}
+	}
+	b.Version = BundleVersion
+}
+
+func validateBundle(b *Bundle) error {
+	if b.Format != BundleFormat {
+		return fmt.Errorf("not a b0k3ts state bundle (format %q)", b.Format)
+	}
+	if b.Version < 1 || b.Version > BundleVersion {
+		return fmt.Errorf("bundle version %d is not supported (this server reads up to %d)", b.Version, BundleVersion)
+	}
+	for name := range b.Sections {
+		if !slices.Contains(SectionNames(), name) {
+			return fmt.Errorf("unknown section %q", name)
+		}
+	}
+	return nil
+}
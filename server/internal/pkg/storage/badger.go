@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"b0k3ts/configs"
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const defaultBadgerDirectory = "/opt/b0k3ts/data"
+
+// Badger is the embedded backend. It locks its directory, so only one
+// process can open it.
+type Badger struct {
+	db *badger.DB
+}
+
+func OpenBadger(cfg configs.BadgerStorageConfig) (*Badger, error) {
+	dir := cfg.Directory
+	if dir == "" {
+		dir = defaultBadgerDirectory
+	}
+
+	db, err := badger.Open(badger.DefaultOptions(dir))
+	if err != nil {
+		return nil, err
+	}
+	return &Badger{db: db}, nil
+}
+
+// DB exposes the underlying database for Badger-only features such as
+// online backups and value-log GC.
+func (b *Badger) DB() *badger.DB {
+	return b.db
+}
+
+func (b *Badger) Backend() string {
+	return BackendBadger
+}
+
+func (b *Badger) View(fn func(tx Tx) error) error {
+	return b.db.View(func(txn *badger.Txn) error { return fn(badgerTx{txn: txn}) })
+}
+
+func (b *Badger) Update(fn func(tx Tx) error) error {
+	return b.db.Update(func(txn *badger.Txn) error { return fn(badgerTx{txn: txn}) })
+}
+
+func (b *Badger) Close() error {
+	return b.db.Close()
+}
+
+type badgerTx struct {
+	txn *badger.Txn
+}
+
+func (t badgerTx) Get(key string) ([]byte, error) {
+	item, err := t.txn.Get([]byte(key))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (t badgerTx) Set(key string, value []byte) error {
+	return t.txn.Set([]byte(key), value)
+}
+
+func (t badgerTx) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	return t.txn.SetEntry(badger.NewEntry([]byte(key), value).WithTTL(ttl))
+}
+
+func (t badgerTx) Delete(key string) error {
+	return t.txn.Delete([]byte(key))
+}
+
+func (t badgerTx) Scan(prefix string, opts ScanOptions, fn func(item Item) error) error {
+	iopts := badger.DefaultIteratorOptions
+	iopts.Prefix = []byte(prefix)
+	iopts.Reverse = opts.Reverse
+	iopts.PrefetchValues = !opts.KeysOnly
+
+	it := t.txn.NewIterator(iopts)
+	defer it.Close()
+
+	// Reverse iteration seeks from just past the last key with the prefix.
+	seek := []byte(prefix)
+	if opts.Reverse {
+		seek = append(seek, 0xFF)
+	}
+
+	for it.Seek(seek); it.ValidForPrefix([]byte(prefix)); it.Next() {
+		item := it.Item()
+		out := Item{Key: string(item.KeyCopy(nil))}
+		if exp := item.ExpiresAt(); exp > 0 {
+			out.ExpiresAt = time.Unix(int64(exp), 0)
+		}
+		if !opts.KeysOnly {
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			out.Value = v
+		}
+
+		if err := fn(out); err != nil {
+			if errors.Is(err, ErrStopScan) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
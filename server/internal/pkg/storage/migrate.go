@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migration upgrades the database from Version-1 to Version. Up must be safe
+// to run again if a previous attempt was interrupted.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(db Store) error
+}
+
+// migrations run in order; append new ones, never reorder or edit old ones.
+var migrations = []Migration{
+	{Version: 1, Name: "move keys into namespaces", Up: migrateNamespaces},
+}
+
+// LatestSchemaVersion is the schema this build writes.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion reads the schema version record; a database without one is
+// at version 0.
+func SchemaVersion(db Store) (int, error) {
+	raw, err := Get(db, KeySchemaVersion)
+	if IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(string(raw))
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q: %w", raw, err)
+	}
+	return v, nil
+}
+
+func setSchemaVersion(db Store, v int) error {
+	return Put(db, KeySchemaVersion, []byte(strconv.Itoa(v)))
+}
+
+// Migrate applies every pending migration in order, recording the schema
+// version after each one. A database written by a newer build is refused.
+func Migrate(db Store) error {
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	latest := LatestSchemaVersion()
+	if current > latest {
+		return fmt.Errorf("database schema version %d is newer than this build supports (%d)", current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		slog.Info("applying storage migration", "version", m.Version, "name", m.Name)
+		if err := m.Up(db); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if err := setSchemaVersion(db, m.Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- Migration 1: namespaced keys ---
+
+// legacyKeys maps exact pre-namespace keys to their new names.
+var legacyKeys = map[string]string{
+	"config":          KeyServerConfig,
+	"oidc-config":     KeyOIDCConfig,
+	"backup-restored": KeyBackupRestored,
+}
+
+// legacyPrefixes maps pre-namespace prefixes to their namespaces.
+var legacyPrefixes = []struct{ from, to string }{
+	{"local_users/", NSUsers},
+	{"bucket-", NSConnections},
+	{"endpoint-", NSEndpoints},
+	{"policy-status-", NSPolicyStatus},
+	{"share-", NSShares},
+	{"upload-request-", NSUploadRequests},
+	{"preview-", NSPreviews},
+	{"extract-job-", NSExtractJobs},
+	{"notification-", NSNotifications},
+	{"audit-", NSAudit},
+	{"kubeconfig-", NSKubeconfigs},
+}
+
+// MigrateKey returns the namespaced form of a pre-namespace key, or key
+// unchanged if it isn't one.
+func MigrateKey(key string) string {
+	if to, ok := legacyKeys[key]; ok {
+		return to
+	}
+	for _, p := range legacyPrefixes {
+		if strings.HasPrefix(key, p.from) {
+			return p.to + strings.TrimPrefix(key, p.from)
+		}
+	}
+	return key
+}
+
+// Each transaction moves at most migrateBatch keys and, past the first key,
+// about migrateBatchBytes of keys and values. Badger refuses a transaction
+// over roughly 10 MiB with its default options, and Postgres keeps every
+// row of it in memory.
+const (
+	migrateBatch      = 500
+	migrateBatchBytes = 4 << 20
+)
+
+type movedKey struct {
+	from, to  string
+	value     []byte
+	expiresAt time.Time
+}
+
+func migrateNamespaces(db Store) error {
+	var moved int
+	for {
+		batch, err := collectLegacyKeys(db, migrateBatch, migrateBatchBytes)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		err = db.Update(func(tx Tx) error {
+			for _, k := range batch {
+				// A key already written under its new name is newer than the
+				// legacy copy (e.g. the restore marker after restoring an old
+				// backup), so the legacy copy is dropped.
+				_, err := tx.Get(k.to)
+				if err != nil && !IsNotFound(err) {
+					return err
+				}
+				if IsNotFound(err) {
+					if err := moveValue(tx, k); err != nil {
+						return err
+					}
+				}
+				if err := tx.Delete(k.from); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		moved += len(batch)
+	}
+
+	slog.Info("moved legacy keys into namespaces", "keys", moved)
+	return nil
+}
+
+// moveValue writes k under its new name, keeping its expiry.
+func moveValue(tx Tx, k movedKey) error {
+	if k.expiresAt.IsZero() {
+		return tx.Set(k.to, k.value)
+	}
+	ttl := time.Until(k.expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return tx.SetWithTTL(k.to, k.value, ttl)
+}
+
+func collectLegacyKeys(db Store, limit, maxBytes int) ([]movedKey, error) {
+	out := make([]movedKey, 0, limit)
+	size := 0
+	err := db.View(func(tx Tx) error {
+		return tx.Scan("", ScanOptions{}, func(item Item) error {
+			to := MigrateKey(item.Key)
+			if to == item.Key {
+				return nil
+			}
+			out = append(out, movedKey{
+				from:      item.Key,
+				to:        to,
+				value:     item.Value,
+				expiresAt: item.ExpiresAt,
+			})
+			size += len(item.Key) + len(to) + len(item.Value)
+			if len(out) == limit || size >= maxBytes {
+				return ErrStopScan
+			}
+			return nil
+		})
+	})
+	return out, err
+}
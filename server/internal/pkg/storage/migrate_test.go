@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"b0k3ts/configs"
+)
+
+func TestMigrateKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "config", want: KeyServerConfig},
+		{key: "oidc-config", want: KeyOIDCConfig},
+		{key: "backup-restored", want: KeyBackupRestored},
+		{key: "local_users/alice", want: NSUsers + "alice"},
+		{key: "bucket-dev-ceph", want: NSConnections + "dev-ceph"},
+		{key: "policy-status-dev-ceph", want: NSPolicyStatus + "dev-ceph"},
+		{key: "upload-request-abc", want: NSUploadRequests + "abc"},
+		{key: "kubeconfig-prod", want: NSKubeconfigs + "prod"},
+
+		// Already namespaced or unknown keys are left alone.
+		{key: NSConnections + "dev-ceph", want: NSConnections + "dev-ceph"},
+		{key: KeySchemaVersion, want: KeySchemaVersion},
+		{key: "configs", want: "configs"},
+		{key: "unrelated", want: "unrelated"},
+	}
+
+	for _, tt := range tests {
+		if got := MigrateKey(tt.key); got != tt.want {
+			t.Errorf("MigrateKey(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestMigrateNamespaces(t *testing.T) {
+	db, err := OpenBadger(configs.BadgerStorageConfig{Directory: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	// More legacy keys than one batch moves.
+	const connections = migrateBatch + 20
+	for i := range connections {
+		mustPut(t, db, fmt.Sprintf("bucket-%04d", i), fmt.Sprintf("conn-%d", i))
+	}
+	// Values below the value log threshold count fully against a Badger
+	// transaction; together they are far larger than one may be.
+	const kubeconfigs = 48
+	big := strings.Repeat("k", 512<<10)
+	for i := range kubeconfigs {
+		mustPut(t, db, fmt.Sprintf("kubeconfig-%02d", i), big)
+	}
+	if err := PutWithTTL(db, "share-link", []byte("share"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	mustPut(t, db, "config", "server")
+
+	// The restore marker already exists under its new name; the legacy
+	// copy is older and must not overwrite it.
+	mustPut(t, db, "backup-restored", "old")
+	mustPut(t, db, KeyBackupRestored, "new")
+
+	if err := migrateNamespaces(db); err != nil {
+		t.Fatal(err)
+	}
+
+	items := map[string]Item{}
+	err = db.View(func(tx Tx) error {
+		return tx.Scan("", ScanOptions{}, func(item Item) error {
+			items[item.Key] = item
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key := range items {
+		if MigrateKey(key) != key {
+			t.Errorf("legacy key %q was not migrated", key)
+		}
+	}
+	if want := connections + kubeconfigs + 3; len(items) != want {
+		t.Errorf("got %d keys after migration, want %d", len(items), want)
+	}
+
+	for i := range connections {
+		key := fmt.Sprintf("%s%04d", NSConnections, i)
+		if got := string(items[key].Value); got != fmt.Sprintf("conn-%d", i) {
+			t.Errorf("%s = %q, want %q", key, got, fmt.Sprintf("conn-%d", i))
+		}
+	}
+	for i := range kubeconfigs {
+		key := fmt.Sprintf("%s%02d", NSKubeconfigs, i)
+		if got := len(items[key].Value); got != len(big) {
+			t.Errorf("%s has %d bytes, want %d", key, got, len(big))
+		}
+	}
+	if got := string(items[KeyServerConfig].Value); got != "server" {
+		t.Errorf("%s = %q, want %q", KeyServerConfig, got, "server")
+	}
+	if got := string(items[KeyBackupRestored].Value); got != "new" {
+		t.Errorf("%s = %q, want the existing value %q", KeyBackupRestored, got, "new")
+	}
+
+	share, ok := items[NSShares+"link"]
+	switch {
+	case !ok:
+		t.Errorf("%slink is missing", NSShares)
+	case share.ExpiresAt.IsZero():
+		t.Errorf("%slink lost its TTL", NSShares)
+	case time.Until(share.ExpiresAt) > time.Hour || time.Until(share.ExpiresAt) < 50*time.Minute:
+		t.Errorf("%slink expires in %v, want about 1h", NSShares, time.Until(share.ExpiresAt))
+	}
+	if !items[KeyServerConfig].ExpiresAt.IsZero() {
+		t.Errorf("%s gained a TTL", KeyServerConfig)
+	}
+}
+
+func mustPut(t *testing.T, db Store, key, value string) {
+	t.Helper()
+	if err := Put(db, key, []byte(value)); err != nil {
+		t.Fatal(err)
+	}
+}
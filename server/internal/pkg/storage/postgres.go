@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"b0k3ts/configs"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the "pgx" database/sql driver
+)
+
+const (
+	defaultPostgresTable        = "b0k3ts_kv"
+	defaultPostgresMaxOpenConns = 10
+
+	postgresConnectTimeout = 10 * time.Second
+	postgresPurgeInterval  = 10 * time.Minute
+	postgresScanPage       = 256
+
+	// Serializable transactions can fail when replicas write the same keys
+	// at once; Update retries them this many times in total.
+	postgresMaxAttempts = 5
+)
+
+var tableNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+// Postgres keeps every key in one table, so any number of replicas can
+// share it. Expired keys are hidden at once and purged by RunJanitor.
+type Postgres struct {
+	db    *sql.DB
+	table string
+}
+
+func OpenPostgres(cfg configs.PostgresStorageConfig) (*Postgres, error) {
+	dsn, err := cfg.DSN.Resolve()
+	if err != nil {
+		return nil, fmt.Errorf("storage.postgres.dsn: %w", err)
+	}
+	if dsn == "" {
+		return nil, errors.New("storage.postgres.dsn is required")
+	}
+
+	table := cfg.Table
+	if table == "" {
+		table = defaultPostgresTable
+	}
+	if !tableNamePattern.MatchString(table) {
+		return nil, fmt.Errorf("storage.postgres.table %q must be a lower-case identifier", table)
+	}
+
+	maxConns := cfg.MaxOpenConns
+	if maxConns <= 0 {
+		maxConns = defaultPostgresMaxOpenConns
+	}
+	// Lock holds a connection of its own while the caller keeps writing.
+	maxConns = max(maxConns, 2)
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(maxConns)
+	db.SetMaxIdleConns(maxConns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), postgresConnectTimeout)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("connect to postgres: %w", err)
+	}
+
+	p := &Postgres{db: db, table: table}
+	if err := p.ensureSchema(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// ensureSchema creates the table under an advisory lock, so replicas
+// starting together don't race on CREATE TABLE.
+func (p *Postgres) ensureSchema(ctx context.Context) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmts := []string{
+		`SELECT pg_advisory_xact_lock(hashtext('` + p.table + `'))`,
+		// COLLATE "C" orders keys bytewise, like Badger.
+		`CREATE TABLE IF NOT EXISTS ` + p.table + ` (
+			key        TEXT COLLATE "C" PRIMARY KEY,
+			value      BYTEA NOT NULL,
+			expires_at TIMESTAMPTZ
+		)`,
+		`CREATE INDEX IF NOT EXISTS ` + p.table + `_expires_at ON ` + p.table + ` (expires_at) WHERE expires_at IS NOT NULL`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("create storage table: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// RunJanitor purges expired keys until ctx is done. One replica running it
+// is enough.
+func (p *Postgres) RunJanitor(ctx context.Context) {
+	ticker := time.NewTicker(postgresPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			res, err := p.db.ExecContext(ctx, `DELETE FROM `+p.table+` WHERE expires_at <= now()`)
+			if err != nil {
+				slog.Error("failed to purge expired keys", "err", err)
+				continue
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				slog.Debug("purged expired keys", "keys", n)
+			}
+		}
+	}
+}
+
+// Lock takes a session advisory lock named after the table and name, waiting
+// for another replica that holds it. The lock lives on a connection of its
+// own and is released by unlock, or by Postgres if the process dies.
+func (p *Postgres) Lock(ctx context.Context, name string) (unlock func(), err error) {
+	conn, err := p.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id := p.table + "/" + name
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, id).Scan(&locked); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if !locked {
+		slog.Info("waiting for another replica to release the storage lock", "lock", name)
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext($1))`, id); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, id); err != nil {
+			slog.Error("failed to release the storage lock", "lock", name, "err", err)
+		}
+		_ = conn.Close()
+	}, nil
+}
+
+func (p *Postgres) Backend() string {
+	return BackendPostgres
+}
+
+func (p *Postgres) View(fn func(tx Tx) error) error {
+	return p.run(&sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, fn)
+}
+
+func (p *Postgres) Update(fn func(tx Tx) error) error {
+	for attempt := 1; ; attempt++ {
+		err := p.run(&sql.TxOptions{Isolation: sql.LevelSerializable}, fn)
+		if !isSerializationFailure(err) || attempt == postgresMaxAttempts {
+			return err
+		}
+	}
+}
+
+func (p *Postgres) run(opts *sql.TxOptions, fn func(tx Tx) error) error {
+	ctx := context.Background()
+
+	tx, err := p.db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if err := fn(postgresTx{ctx: ctx, tx: tx, table: p.table}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) Close() error {
+	return p.db.Close()
+}
+
+func isSerializationFailure(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "40001"
+}
+
+type postgresTx struct {
+	ctx   context.Context
+	tx    *sql.Tx
+	table string
+}
+
+const liveRow = `(expires_at IS NULL OR expires_at > now())`
+
+func (t postgresTx) Get(key string) ([]byte, error) {
+	var v []byte
+	err := t.tx.QueryRowContext(t.ctx, `SELECT value FROM `+t.table+` WHERE key = $1 AND `+liveRow, key).Scan(&v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return v, err
+}
+
+func (t postgresTx) Set(key string, value []byte) error {
+	return t.upsert(key, value, nil)
+}
+
+func (t postgresTx) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	secs := ttl.Seconds()
+	return t.upsert(key, value, &secs)
+}
+
+func (t postgresTx) upsert(key string, value []byte, ttlSeconds *float64) error {
+	if value == nil {
+		value = []byte{}
+	}
+	_, err := t.tx.ExecContext(t.ctx, `
+		INSERT INTO `+t.table+` (key, value, expires_at)
+		VALUES ($1, $2, now() + make_interval(secs => $3::float8))
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at`,
+		key, value, ttlSeconds)
+	return err
+}
+
+func (t postgresTx) Delete(key string) error {
+	_, err := t.tx.ExecContext(t.ctx, `DELETE FROM `+t.table+` WHERE key = $1`, key)
+	return err
+}
+
+// Scan reads a page at a time and closes the rows before calling fn, so fn
+// can use the transaction too.
+func (t postgresTx) Scan(prefix string, opts ScanOptions, fn func(item Item) error) error {
+	cols, cmp, order := "key, value, expires_at", ">", "ASC"
+	if opts.KeysOnly {
+		cols = "key, NULL::bytea, expires_at"
+	}
+	if opts.Reverse {
+		cmp, order = "<", "DESC"
+	}
+	query := `SELECT ` + cols + ` FROM ` + t.table + `
+		WHERE starts_with(key, $1) AND ($2::text IS NULL OR key ` + cmp + ` $2) AND ` + liveRow + `
+		ORDER BY key ` + order + ` LIMIT ` + fmt.Sprint(postgresScanPage)
+
+	var cursor *string
+	for {
+		page, err := t.scanPage(query, prefix, cursor)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range page {
+			if err := fn(item); err != nil {
+				if errors.Is(err, ErrStopScan) {
+					return nil
+				}
+				return err
+			}
+		}
+		if len(page) < postgresScanPage {
+			return nil
+		}
+		cursor = &page[len(page)-1].Key
+	}
+}
+
+func (t postgresTx) scanPage(query, prefix string, cursor *string) ([]Item, error) {
+	rows, err := t.tx.QueryContext(t.ctx, query, prefix, cursor)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := make([]Item, 0, postgresScanPage)
+	for rows.Next() {
+		var (
+			item      Item
+			expiresAt sql.NullTime
+		)
+		if err := rows.Scan(&item.Key, &item.Value, &expiresAt); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			item.ExpiresAt = expiresAt.Time
+		}
+		page = append(page, item)
+	}
+	return page, rows.Err()
+}
@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"b0k3ts/configs"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// --- Key namespaces ---
+
+// Every key lives under one of these namespaces. Entities are stored as
+// <namespace><id>.
+const (
+	NSMeta           = "meta/"
+	NSSettings       = "settings/"
+	NSUsers          = "users/"
+	NSConnections    = "connections/"
+	NSEndpoints      = "endpoints/"
+	NSPolicyStatus   = "policy-status/"
+	NSShares         = "shares/"
+	NSUploadRequests = "upload-requests/"
+	NSPreviews       = "previews/"
+	NSExtractJobs    = "extract-jobs/"
+	NSJobHeartbeats  = "job-heartbeats/"
+	NSEvents         = "events/"
+	NSNotifications  = "notifications/"
+	NSAudit          = "audit/"
+	NSKubeconfigs    = "kubeconfigs/"
+	NSBackup         = "backup/"
+
+	SettingServer = "server" // config.yaml as loaded at startup
+	SettingOIDC   = "oidc"
+
+	KeySchemaVersion  = NSMeta + "schema_version"
+	KeyServerConfig   = NSSettings + SettingServer
+	KeyOIDCConfig     = NSSettings + SettingOIDC
+	KeyBackupRestored = NSBackup + "restored"
+	KeyHealthCheck    = NSMeta + "health_check/" // + hostname; written by readiness probes
+)
+
+var (
+	// ErrNotFound is returned when a key doesn't exist (or has expired).
+	ErrNotFound = errors.New("key not found")
+
+	// ErrStopScan ends a Scan early without failing it.
+	ErrStopScan = errors.New("stop scan")
+)
+
+// IsNotFound reports whether err means the key doesn't exist.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// --- Backends ---
+
+const (
+	BackendBadger   = "badger"
+	BackendPostgres = "postgres"
+)
+
+// Store is a transactional key-value store. Keys are compared bytewise, so
+// scans return them in the same order on every backend.
+type Store interface {
+	// View runs fn in a read-only transaction.
+	View(fn func(tx Tx) error) error
+	// Update runs fn in a read-write transaction, committed if fn returns nil.
+	Update(fn func(tx Tx) error) error
+	// Backend names the implementation (BackendBadger or BackendPostgres).
+	Backend() string
+	Close() error
+}
+
+// Janitor is implemented by backends that need periodic cleanup. Badger
+// expires keys on its own.
+type Janitor interface {
+	RunJanitor(ctx context.Context)
+}
+
+// RunJanitor runs the backend's cleanup, if it has any, until ctx is done.
+func RunJanitor(ctx context.Context, db Store) {
+	if j, ok := db.(Janitor); ok {
+		j.RunJanitor(ctx)
+	}
+}
+
+// Locker is implemented by backends that several replicas can share, so
+// one-off work such as startup migrations runs on one replica at a time.
+// Badger is opened by a single process.
+type Locker interface {
+	Lock(ctx context.Context, name string) (unlock func(), err error)
+}
+
+// LockStartup serializes the writes each replica makes while starting.
+const LockStartup = "startup"
+
+// Lock takes the named lock if the backend has one, waiting until it's
+// free. unlock must be called once the work is done.
+func Lock(ctx context.Context, db Store, name string) (unlock func(), err error) {
+	if l, ok := db.(Locker); ok {
+		return l.Lock(ctx, name)
+	}
+	return func() {}, nil
+}
+
+// Tx is a transaction on a Store.
+type Tx interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+	// SetWithTTL stores a value that disappears once ttl has passed.
+	SetWithTTL(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+	// Scan calls fn for every key with prefix, in key order. Returning
+	// ErrStopScan from fn ends the scan.
+	Scan(prefix string, opts ScanOptions, fn func(item Item) error) error
+}
+
+type ScanOptions struct {
+	Reverse  bool
+	KeysOnly bool // Item.Value is left nil
+}
+
+type Item struct {
+	Key       string
+	Value     []byte
+	ExpiresAt time.Time // zero if the key doesn't expire
+}
+
+// Open opens the backend selected in config.yaml.
+func Open(cfg configs.StorageConfig) (Store, error) {
+	switch cfg.Backend {
+	case "", BackendBadger:
+		return OpenBadger(cfg.Badger)
+	case BackendPostgres:
+		return OpenPostgres(cfg.Postgres)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (want %s or %s)", cfg.Backend, BackendBadger, BackendPostgres)
+	}
+}
+
+// --- Single-key helpers ---
+
+func Get(db Store, key string) ([]byte, error) {
+	var v []byte
+	err := db.View(func(tx Tx) error {
+		var err error
+		v, err = tx.Get(key)
+		return err
+	})
+	return v, err
+}
+
+func Put(db Store, key string, value []byte) error {
+	return db.Update(func(tx Tx) error { return tx.Set(key, value) })
+}
+
+func PutWithTTL(db Store, key string, value []byte, ttl time.Duration) error {
+	return db.Update(func(tx Tx) error { return tx.SetWithTTL(key, value, ttl) })
+}
+
+func Delete(db Store, key string) error {
+	return db.Update(func(tx Tx) error { return tx.Delete(key) })
+}
+
+// --- Repositories ---
+
+// Repository stores values of one type under a namespace. Structs are
+// JSON-encoded; []byte values are stored as is.
+type Repository[T any] struct {
+	db Store
+	ns string
+}
+
+func NewRepository[T any](db Store, ns string) Repository[T] {
+	return Repository[T]{db: db, ns: ns}
+}
+
+// Settings returns a repository over the settings namespace, keyed by the
+// Setting* names.
+func Settings[T any](db Store) Repository[T] {
+	return NewRepository[T](db, NSSettings)
+}
+
+// Key returns the store key for id.
+func (r Repository[T]) Key(id string) string {
+	return r.ns + id
+}
+
+func (r Repository[T]) Get(id string) (T, error) {
+	var v T
+	err := r.db.View(func(tx Tx) error {
+		var err error
+		v, err = r.Tx(tx).Get(id)
+		return err
+	})
+	return v, err
+}
+
+func (r Repository[T]) Exists(id string) (bool, error) {
+	_, err := Get(r.db, r.Key(id))
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r Repository[T]) Put(id string, v T) error {
+	return r.db.Update(func(tx Tx) error { return r.Tx(tx).Put(id, v) })
+}
+
+// PutWithTTL stores v until ttl passes.
+func (r Repository[T]) PutWithTTL(id string, v T, ttl time.Duration) error {
+	raw, err := encode(v)
+	if err != nil {
+		return err
+	}
+	return PutWithTTL(r.db, r.Key(id), raw, ttl)
+}
+
+func (r Repository[T]) Delete(id string) error {
+	return r.db.Update(func(tx Tx) error { return r.Tx(tx).Delete(id) })
+}
+
+// List returns every value in the namespace, ordered by id.
+func (r Repository[T]) List() ([]T, error) {
+	out := make([]T, 0)
+	err := r.db.View(func(tx Tx) error {
+		return tx.Scan(r.ns, ScanOptions{}, func(item Item) error {
+			v, err := decode[T](item.Value)
+			if err != nil {
+				return err
+			}
+			out = append(out, v)
+			return nil
+		})
+	})
+	return out, err
+}
+
+// IDs returns every id in the namespace, in order.
+func (r Repository[T]) IDs() ([]string, error) {
+	out := make([]string, 0)
+	err := r.db.View(func(tx Tx) error {
+		return tx.Scan(r.ns, ScanOptions{KeysOnly: true}, func(item Item) error {
+			out = append(out, strings.TrimPrefix(item.Key, r.ns))
+			return nil
+		})
+	})
+	return out, err
+}
+
+// TxRepository is a repository bound to a transaction.
+type TxRepository[T any] struct {
+	r  Repository[T]
+	tx Tx
+}
+
+func (r Repository[T]) Tx(tx Tx) TxRepository[T] {
+	return TxRepository[T]{r: r, tx: tx}
+}
+
+func (t TxRepository[T]) Get(id string) (T, error) {
+	raw, err := t.tx.Get(t.r.Key(id))
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return decode[T](raw)
+}
+
+func (t TxRepository[T]) Put(id string, v T) error {
+	raw, err := encode(v)
+	if err != nil {
+		return err
+	}
+	return t.tx.Set(t.r.Key(id), raw)
+}
+
+func (t TxRepository[T]) Delete(id string) error {
+	return t.tx.Delete(t.r.Key(id))
+}
+
+func encode[T any](v T) ([]byte, error) {
+	if b, ok := any(v).([]byte); ok {
+		return b, nil
+	}
+	return json.Marshal(v)
+}
+
+func decode[T any](raw []byte) (T, error) {
+	var v T
+	if p, ok := any(&v).(*[]byte); ok {
+		*p = slices.Clone(raw)
+		return v, nil
+	}
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
@@ -0,0 +1,171 @@
+package tlsreload
+
+import (
+	"b0k3ts/configs"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ClientAuthRequire  = "require"
+	ClientAuthOptional = "optional"
+
+	defaultReloadInterval = 30 * time.Second
+)
+
+// Reloader serves the certificate and client CAs from configs.ServerTLSConfig
+// and re-reads them when the files change. A file that fails to load keeps
+// the previous one in use.
+type Reloader struct {
+	cfg        configs.ServerTLSConfig
+	clientAuth tls.ClientAuthType
+
+	current atomic.Pointer[tls.Config]
+	stamps  map[string]stamp
+}
+
+// stamp identifies a file version. Kubernetes swaps mounted secrets through
+// a symlink, which os.Stat follows.
+type stamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// New loads the files once, so a bad certificate fails startup.
+func New(cfg configs.ServerTLSConfig) (*Reloader, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("tls.certFile and tls.keyFile are both required")
+	}
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = defaultReloadInterval
+	}
+
+	r := &Reloader{cfg: cfg, clientAuth: tls.NoClientCert}
+	if cfg.ClientCAFile != "" {
+		switch cfg.ClientAuth {
+		case "", ClientAuthOptional:
+			r.clientAuth = tls.VerifyClientCertIfGiven
+		case ClientAuthRequire:
+			r.clientAuth = tls.RequireAndVerifyClientCert
+		default:
+			return nil, fmt.Errorf("tls.clientAuth %q must be %s or %s", cfg.ClientAuth, ClientAuthOptional, ClientAuthRequire)
+		}
+	} else if cfg.ClientAuth != "" {
+		return nil, errors.New("tls.clientAuth needs tls.clientCAFile")
+	}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns a server config that hands each handshake the most
+// recently loaded files, negotiating HTTP/2 where the client supports it.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current.Load(), nil
+		},
+	}
+}
+
+// Watch checks the files every reload interval until ctx is done.
+func (r *Reloader) Watch(ctx context.Context) {
+	t := time.NewTicker(r.cfg.ReloadInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.load(); err != nil {
+				slog.Error("failed to reload tls certificate, keeping the previous one", "err", err)
+				continue
+			}
+			slog.Info("tls certificate reloaded", "cert", r.cfg.CertFile)
+		}
+	}
+}
+
+// --- Loading ---
+
+func (r *Reloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+func (r *Reloader) changed() bool {
+	for _, f := range r.files() {
+		st, err := os.Stat(f)
+		if err != nil {
+			// Mid-rotation; try again next tick.
+			continue
+		}
+		if (stamp{st.ModTime(), st.Size()}) != r.stamps[f] {
+			return true
+		}
+	}
+	return false
+}
+
+// load reads every file and swaps in a new config. The file versions are
+// recorded even if loading fails, so a bad file is retried (and logged)
+// only once it changes again. Watch is the only caller after New, so stamps
+// needs no lock.
+func (r *Reloader) load() error {
+	stamps := make(map[string]stamp, 3)
+	for _, f := range r.files() {
+		st, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		stamps[f] = stamp{st.ModTime(), st.Size()}
+	}
+	r.stamps = stamps
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load tls certificate: %w", err)
+	}
+	if cert.Leaf != nil && time.Now().After(cert.Leaf.NotAfter) {
+		slog.Warn("tls certificate has expired", "cert", r.cfg.CertFile, "notAfter", cert.Leaf.NotAfter)
+	}
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   r.clientAuth,
+	}
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("load tls client CAs: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("load tls client CAs: no PEM certificates in %s", r.cfg.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+	}
+
+	r.current.Store(cfg)
+	return nil
+}
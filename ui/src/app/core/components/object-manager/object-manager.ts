@@ -14,7 +14,7 @@ import { MatTreeModule, MatTreeNestedDataSource } from '@angular/material/tree';
 import { MatInputModule } from '@angular/material/input';
 import { MatTableModule } from '@angular/material/table';
 
-import { ObjectStorageService } from '../../services/object-storage';
+import { ObjectLockStatus, ObjectStorageService } from '../../services/object-storage';
 import { MovePrefixDialog, MovePrefixDialogResult } from '../move-prefix-dialog/move-prefix-dialog';
 import { GlobalService } from '../../services/global';
 import { BucketConfigsService } from '../../services/bucket-configs';
@@ -27,6 +27,9 @@ type BucketObject = {
   contentType: string;
 };
 
+// The most keys one lock status request takes.
+const LOCK_STATUS_BATCH = 200;
+
 type TreeNode =
   | {
       kind: 'dir';
@@ -101,6 +104,15 @@ export class ObjectManager implements OnInit {
     effect(() => {
       this.dataSource.data = this.buildTree(this.objects());
     });
+
+    // Lock state is fetched for the files in the folder on screen only.
+    effect(() => {
+      const bucket = this.selectedBucket();
+      const keys = this.explorerRows()
+        .filter((r) => r.kind === 'file')
+        .map((r) => r.path);
+      void this.loadLockStatus(bucket, keys);
+    });
   }
 
   ngOnInit() {
@@ -113,6 +125,10 @@ export class ObjectManager implements OnInit {
   readonly objects = signal<BucketObject[]>([]);
   readonly objectCount = computed(() => this.objects().length);
 
+  // Lock state by key for the files shown; empty on buckets without object lock.
+  readonly lockStatus = signal<Map<string, ObjectLockStatus>>(new Map());
+  private lockStatusRequest = 0;
+
   readonly uploadPrefix = signal<string>(''); // e.g. "reports/2026/"
 
   // Explorer navigation state: current folder ('' is root, otherwise no trailing slash)
@@ -313,6 +329,45 @@ export class ObjectManager implements OnInit {
     if (row.kind === 'dir') this.goToDir(row.path);
   }
 
+  lockLabel(row: ExplorerRow): string {
+    if (row.kind === 'dir') return '';
+    const st = this.lockStatus().get(row.path);
+    if (!st?.locked) return '';
+
+    const parts: string[] = [];
+    if (st.mode && st.retain_until) {
+      parts.push(`${st.mode} until ${new Date(st.retain_until).toLocaleString()}`);
+    }
+    if (st.legal_hold) parts.push('legal hold');
+    return `Locked: ${parts.join(', ')}`;
+  }
+
+  private async loadLockStatus(bucket: string, keys: string[]): Promise<void> {
+    const request = ++this.lockStatusRequest;
+    if (!bucket || keys.length === 0) {
+      this.lockStatus.set(new Map());
+      return;
+    }
+
+    const next = new Map<string, ObjectLockStatus>();
+    try {
+      for (let i = 0; i < keys.length; i += LOCK_STATUS_BATCH) {
+        const res = await this.storage.getObjectLockStatus({
+          bucket,
+          keys: keys.slice(i, i + LOCK_STATUS_BATCH),
+        });
+        if (!res.enabled) break;
+        for (const [key, st] of Object.entries(res.objects)) next.set(key, st);
+      }
+    } catch (e) {
+      console.error('Failed to load object lock status', e);
+    }
+
+    // The folder or bucket may have changed while this was loading.
+    if (request !== this.lockStatusRequest) return;
+    this.lockStatus.set(next);
+  }
+
   fileTypeLabel(row: ExplorerRow): string {
     if (row.kind === 'dir') return 'File folder';
     const name = row.name;
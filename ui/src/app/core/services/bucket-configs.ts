@@ -13,6 +13,8 @@ export type BucketConfig = {
 
   authorized_users: string[]; // Email
   authorized_groups: string[];
+
+  managed?: boolean; // declared in the server's config.yaml; read-only
 };
 
 @Injectable({ providedIn: 'root' })
@@ -28,6 +28,18 @@ export type ObjectApiItem = {
   content_type: string;
 };
 
+export type ObjectLockStatus = {
+  mode?: 'GOVERNANCE' | 'COMPLIANCE';
+  retain_until?: string;
+  legal_hold: boolean;
+  locked: boolean; // retention still active or legal hold on
+};
+
+type ObjectLockStatusResponse = {
+  enabled: boolean;
+  objects: Record<string, ObjectLockStatus>; // by key; empty unless enabled
+};
+
 type MultipartInitiateRequest = {
   bucket: string;
   key: string;
@@ -90,6 +102,18 @@ export class ObjectStorageService {
     return await firstValueFrom(this.http.post<ObjectApiItem[]>(url, params));
   }
 
+  /**
+   * Lock state of the given keys (at most 200 per call). Listings don't carry it,
+   * since the server needs one HEAD request per object.
+   */
+  async getObjectLockStatus(params: {
+    bucket: string;
+    keys: string[];
+  }): Promise<ObjectLockStatusResponse> {
+    const url = `${this.apiBase}/api/v1/objects/lock/status`;
+    return await firstValueFrom(this.http.post<ObjectLockStatusResponse>(url, params));
+  }
+
   private normalizeEtag(etag: string): string {
     const trimmed = etag.trim();
     if (trimmed.startsWith('"') && trimmed.endsWith('"') && trimmed.length >= 2) {